@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"os/signal"
+	"runtime"
 	"syscall"
 	"time"
 
@@ -16,9 +17,11 @@ import (
 	"github.com/zasper-io/zasper/internal/auth"
 	"github.com/zasper-io/zasper/internal/content"
 	"github.com/zasper-io/zasper/internal/core"
+	"github.com/zasper-io/zasper/internal/gateway"
 	"github.com/zasper-io/zasper/internal/gitclient"
 	"github.com/zasper-io/zasper/internal/health"
 	"github.com/zasper-io/zasper/internal/kernel"
+	"github.com/zasper-io/zasper/internal/kernel/provisioner"
 	"github.com/zasper-io/zasper/internal/kernelspec"
 	"github.com/zasper-io/zasper/internal/search"
 	"github.com/zasper-io/zasper/internal/session"
@@ -85,6 +88,26 @@ func main() {
 	port := flag.String("port", ":8048", "port to start the server on")
 	protected := flag.Bool("protected", false, "enable protected mode")
 	tracking := flag.Bool("tracking", true, "enable usage tracking")
+	cullIdleTimeout := flag.Int("cull-idle-timeout", 0, "shut down kernels idle for this many seconds (0 disables culling)")
+	cullInterval := flag.Int("cull-interval", 300, "seconds between checks for idle kernels")
+	cullBusy := flag.Bool("cull-busy", false, "also cull kernels that are busy")
+	cullConnected := flag.Bool("cull-connected", false, "also cull kernels with connected clients")
+	heartbeatInterval := flag.Int("kernel-heartbeat-interval", 3, "seconds between heartbeats sent to each kernel (0 disables heartbeat monitoring)")
+	heartbeatMisses := flag.Int("kernel-heartbeat-misses", 5, "missed heartbeats after which a kernel is unresponsive")
+	heartbeatRestart := flag.Bool("kernel-heartbeat-restart", false, "restart unresponsive kernels")
+	inputTimeout := flag.Int("kernel-input-timeout", 60, "seconds an input() waits for a client to reconnect before it is interrupted")
+	replayBufferSize := flag.Int("kernel-replay-buffer", 10, "megabytes of kernel output kept for a disconnected client until it reconnects (0 disables replay)")
+	iopubMsgRateLimit := flag.Float64("iopub-msg-rate-limit", 1000, "maximum rate of kernel output messages sent to a client, in messages per second (0 disables the limit)")
+	iopubDataRateLimit := flag.Float64("iopub-data-rate-limit", 1000000, "maximum rate of kernel output sent to a client, in bytes per second (0 disables the limit)")
+	rateLimitWindow := flag.Float64("rate-limit-window", 3, "seconds over which the kernel output rates are averaged")
+	kernelMemoryMax := flag.String("kernel-memory-max", "", "maximum memory of each kernel, e.g. 4G")
+	kernelCPUQuota := flag.Float64("kernel-cpu-quota", 0, "maximum number of CPUs used by each kernel")
+	kernelPidsMax := flag.Int64("kernel-pids-max", 0, "maximum number of processes of each kernel")
+	kernelCgroup := flag.String("kernel-cgroup", "", "cgroup v2 directory delegated to Zasper, under which kernels are limited (default: the cgroup of the server)")
+	kernelTransport := flag.String("kernel-transport", "tcp", "transport of the channels of local kernels: tcp or ipc")
+	defaultKernel := flag.String("default-kernel", "", "kernelspec used for notebooks that do not name one")
+	gatewayURL := flag.String("gateway-url", os.Getenv("JUPYTER_GATEWAY_URL"), "run kernels on this Jupyter Kernel or Enterprise Gateway")
+	gatewayAuthToken := flag.String("gateway-auth-token", os.Getenv("JUPYTER_GATEWAY_AUTH_TOKEN"), "token sent to the gateway")
 
 	flag.Parse()
 
@@ -124,6 +147,58 @@ func main() {
 	kernel.ZasperActiveKernels = kernel.SetUpStateKernels()
 	websocket.ZasperActiveKernelConnections = websocket.SetUpStateKernels()
 	kernel.ProtocolVersion = "5.3"
+	kernelspec.DefaultKernelName = *defaultKernel
+
+	memoryMax, err := provisioner.ParseMemorySize(*kernelMemoryMax)
+	if err != nil {
+		log.Fatal().Msgf("Invalid -kernel-memory-max: %v", err)
+	}
+	provisioner.CgroupParent = *kernelCgroup
+	kernel.KernelResourceLimits = provisioner.ResourceLimits{
+		MemoryMax: memoryMax,
+		CPUQuota:  *kernelCPUQuota,
+		PidsMax:   *kernelPidsMax,
+	}
+	if err := provisioner.CheckLimits(kernel.KernelResourceLimits); err != nil {
+		log.Warn().Msgf("Kernel resource limits are not fully enforced, %v", err)
+	}
+
+	switch {
+	case *kernelTransport != "tcp" && *kernelTransport != "ipc":
+		log.Fatal().Msgf("Invalid -kernel-transport %q: use tcp or ipc", *kernelTransport)
+	case *kernelTransport == "ipc" && runtime.GOOS == "windows":
+		log.Fatal().Msg("The ipc kernel transport is not available on Windows")
+	}
+	kernel.KernelTransport = *kernelTransport
+	kernel.InputTimeout = time.Duration(*inputTimeout) * time.Second
+	kernel.ReplayBufferSize = *replayBufferSize << 20
+	kernel.IOPubRateLimits = kernel.IOPubRateLimit{
+		MsgRate:  *iopubMsgRateLimit,
+		DataRate: *iopubDataRateLimit,
+		Window:   time.Duration(*rateLimitWindow * float64(time.Second)),
+	}
+	kernel.Heartbeat = kernel.HeartbeatConfig{
+		Interval:  time.Duration(*heartbeatInterval) * time.Second,
+		MaxMissed: max(*heartbeatMisses, 1),
+		Restart:   *heartbeatRestart,
+	}
+
+	if *gatewayURL != "" {
+		gateway.Gateway, err = gateway.NewClient(*gatewayURL, *gatewayAuthToken)
+		if err != nil {
+			log.Fatal().Msgf("Invalid -gateway-url: %v", err)
+		}
+		log.Info().Msgf("running kernels on the gateway %s", gateway.Gateway.URL)
+	}
+
+	cullerCtx, stopCuller := context.WithCancel(context.Background())
+	defer stopCuller()
+	kernel.StartCuller(cullerCtx, kernel.CullerConfig{
+		IdleTimeout:   time.Duration(*cullIdleTimeout) * time.Second,
+		Interval:      time.Duration(*cullInterval) * time.Second,
+		CullBusy:      *cullBusy,
+		CullConnected: *cullConnected,
+	})
 
 	// API routes
 	apiRouter := router.PathPrefix("/api").Subrouter()
@@ -163,25 +238,63 @@ func main() {
 	apiRouter.HandleFunc("/commit-and-maybe-push", gitclient.CommitAndMaybePushHandler).Methods("POST")
 	apiRouter.HandleFunc("/current-branch", gitclient.BranchHandler).Methods("GET")
 
-	// kernelspecs
-	apiRouter.HandleFunc("/kernelspecs", kernelspec.KernelspecAPIHandler).Methods("GET")
-	apiRouter.HandleFunc("/kernelspecs/{kernelName}", kernelspec.SingleKernelspecAPIHandler).Methods("GET")
-	staticRouter.HandleFunc("/kernelspecs/{kernel}/{resource}", kernelspec.ServeKernelResource).Methods("GET")
-
-	// kernels
-	apiRouter.HandleFunc("/kernels", kernel.KernelListAPIHandler).Methods("GET")
-	apiRouter.HandleFunc("/kernels/{kernelId}", kernel.KernelReadAPIHandler).Methods("GET")
-	apiRouter.HandleFunc("/kernels/{kernelId}/interrupt", kernel.KernelInterruptAPIHandler).Methods("POST")
-	apiRouter.HandleFunc("/kernels/{kernelId}/stop", kernel.KernelKillAPIHandler).Methods("POST")
+	if gateway.Enabled() {
+		// kernelspecs and kernels live on the gateway
+		apiRouter.HandleFunc("/kernelspecs", gateway.KernelspecAPIHandler).Methods("GET")
+		apiRouter.HandleFunc("/kernelspecs/refresh", gateway.KernelspecAPIHandler).Methods("POST")
+		apiRouter.HandleFunc("/kernelspecs/{kernelName}", gateway.SingleKernelspecAPIHandler).Methods("GET")
+		apiRouter.HandleFunc("/kernelspecs", gateway.NotSupportedAPIHandler).Methods("POST")
+		apiRouter.HandleFunc("/kernelspecs/{kernelName}", gateway.NotSupportedAPIHandler).Methods("PATCH", "DELETE")
+		staticRouter.HandleFunc("/kernelspecs/{kernel}/{resource}", gateway.ServeKernelResource).Methods("GET")
+
+		apiRouter.HandleFunc("/kernels", gateway.KernelListAPIHandler).Methods("GET")
+		apiRouter.HandleFunc("/kernels/resources", gateway.NotSupportedAPIHandler).Methods("GET")
+		apiRouter.HandleFunc("/kernels/attach", gateway.NotSupportedAPIHandler).Methods("POST")
+		apiRouter.HandleFunc("/kernels/{kernelId}", gateway.KernelReadAPIHandler).Methods("GET")
+		apiRouter.HandleFunc("/kernels/{kernelId}/interrupt", gateway.KernelInterruptAPIHandler).Methods("POST")
+		apiRouter.HandleFunc("/kernels/{kernelId}/restart", gateway.KernelRestartAPIHandler).Methods("POST")
+		apiRouter.HandleFunc("/kernels/{kernelId}/stop", gateway.KernelKillAPIHandler).Methods("POST")
+		apiRouter.HandleFunc("/kernels/{kernelId}/logs", gateway.NotSupportedAPIHandler).Methods("GET")
+		apiRouter.HandleFunc("/kernels/{kernelId}/connection", gateway.NotSupportedAPIHandler).Methods("GET")
+		apiRouter.HandleFunc("/kernels/{kernelId}/resources", gateway.NotSupportedAPIHandler).Methods("GET")
+	} else {
+		// kernelspecs
+		apiRouter.HandleFunc("/kernelspecs", kernelspec.KernelspecAPIHandler).Methods("GET")
+		apiRouter.HandleFunc("/kernelspecs", kernelspec.KernelspecInstallAPIHandler).Methods("POST")
+		apiRouter.HandleFunc("/kernelspecs/refresh", kernelspec.KernelspecRefreshAPIHandler).Methods("POST")
+		apiRouter.HandleFunc("/kernelspecs/{kernelName}", kernelspec.SingleKernelspecAPIHandler).Methods("GET")
+		apiRouter.HandleFunc("/kernelspecs/{kernelName}", kernelspec.KernelspecUpdateAPIHandler).Methods("PATCH")
+		apiRouter.HandleFunc("/kernelspecs/{kernelName}", kernelspec.KernelspecDeleteAPIHandler).Methods("DELETE")
+		staticRouter.HandleFunc("/kernelspecs/{kernel}/{resource}", kernelspec.ServeKernelResource).Methods("GET")
+
+		// kernels
+		apiRouter.HandleFunc("/kernels", kernel.KernelListAPIHandler).Methods("GET")
+		apiRouter.HandleFunc("/kernels/resources", kernel.KernelResourcesListAPIHandler).Methods("GET")
+		apiRouter.HandleFunc("/kernels/attach", kernel.KernelAttachAPIHandler).Methods("POST")
+		apiRouter.HandleFunc("/kernels/{kernelId}", kernel.KernelReadAPIHandler).Methods("GET")
+		apiRouter.HandleFunc("/kernels/{kernelId}/interrupt", kernel.KernelInterruptAPIHandler).Methods("POST")
+		apiRouter.HandleFunc("/kernels/{kernelId}/restart", kernel.KernelRestartAPIHandler).Methods("POST")
+		apiRouter.HandleFunc("/kernels/{kernelId}/stop", kernel.KernelKillAPIHandler).Methods("POST")
+		apiRouter.HandleFunc("/kernels/{kernelId}/logs", kernel.KernelLogsAPIHandler).Methods("GET")
+		apiRouter.HandleFunc("/kernels/{kernelId}/connection", kernel.KernelConnectionAPIHandler).Methods("GET")
+		apiRouter.HandleFunc("/kernels/{kernelId}/resources", kernel.KernelResourcesAPIHandler).Methods("GET")
+	}
 
 	// sessions
 	apiRouter.HandleFunc("/sessions", session.SessionApiHandler).Methods("GET")
 	apiRouter.HandleFunc("/sessions", session.SessionCreateApiHandler).Methods("POST")
 
 	//web sockets
-	wsRouter.HandleFunc("/kernels/{kernelId}/channels", websocket.HandleWebSocket)
-	wsRouter.HandleFunc("/kernels/{kernel_id}", websocket.KernelDeleteAPIHandler).Methods("DELETE")
+	if gateway.Enabled() {
+		wsRouter.HandleFunc("/kernels/{kernelId}/channels", gateway.HandleWebSocket)
+		wsRouter.HandleFunc("/kernels/{kernel_id}", gateway.KernelKillAPIHandler).Methods("DELETE")
+	} else {
+		wsRouter.HandleFunc("/kernels/{kernelId}/channels", websocket.HandleWebSocket)
+		wsRouter.HandleFunc("/kernels/{kernelId}/logs", websocket.HandleKernelLogsWebSocket)
+		wsRouter.HandleFunc("/kernels/{kernel_id}", websocket.KernelDeleteAPIHandler).Methods("DELETE")
+	}
 	wsRouter.HandleFunc("/terminals/{terminalId}", websocket.HandleTerminalWebSocket)
+	wsRouter.HandleFunc("/events", websocket.HandleEventsWebSocket)
 
 	//cors optionsGoes Below
 	corsOpts := cors.New(cors.Options{
@@ -268,4 +381,7 @@ func cleanup(tracking bool) {
 	}
 	fmt.Println("Performing cleanup...")
 	kernel.Cleanup()
+	if gateway.Enabled() {
+		gateway.Gateway.Cleanup()
+	}
 }
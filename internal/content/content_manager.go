@@ -381,7 +381,8 @@ func GetSafePath(path string) string {
 		return ""
 	}
 
-	if !strings.HasPrefix(absPathResolved, core.Zasper.HomeDir) {
+	homeDir := filepath.Clean(core.Zasper.HomeDir)
+	if absPathResolved != homeDir && !strings.HasPrefix(absPathResolved, homeDir+string(filepath.Separator)) {
 		log.Printf("Warning: Path traversal detected. The path %s is outside the allowed directory %s", absPathResolved, core.Zasper.HomeDir)
 		return ""
 	}
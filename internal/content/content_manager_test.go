@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/zasper-io/zasper/internal/core"
 	"github.com/zasper-io/zasper/internal/models"
 )
 
@@ -194,3 +195,59 @@ func TestGetFileModel(t *testing.T) {
 		})
 	}
 }
+
+func TestGetSafePath(t *testing.T) {
+	root := t.TempDir()
+	home := filepath.Join(root, "home")
+	previous := core.Zasper.HomeDir
+	core.Zasper.HomeDir = home
+	defer func() { core.Zasper.HomeDir = previous }()
+
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "File in the home dir",
+			path:     "notebooks/analysis.ipynb",
+			expected: filepath.Join(home, "notebooks", "analysis.ipynb"),
+		},
+		{
+			name:     "Home dir itself",
+			path:     "",
+			expected: home,
+		},
+		{
+			name:     "Home dir as root",
+			path:     "/",
+			expected: home,
+		},
+		{
+			name:     "Traversal that stays in the home dir",
+			path:     "notebooks/../data.csv",
+			expected: filepath.Join(home, "data.csv"),
+		},
+		{
+			name:     "Traversal out of the home dir",
+			path:     "../secret.txt",
+			expected: "",
+		},
+		{
+			name:     "Sibling dir sharing the home dir prefix",
+			path:     "../home2/file.txt",
+			expected: "",
+		},
+		{
+			name:     "Sibling dir itself",
+			path:     "../home2",
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, GetSafePath(tt.path))
+		})
+	}
+}
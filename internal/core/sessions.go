@@ -1,9 +1,87 @@
 package core
 
-import "github.com/zasper-io/zasper/internal/models"
+import (
+	"sync"
 
-var ZasperSession map[string]models.SessionModel
+	"github.com/zasper-io/zasper/internal/events"
+	"github.com/zasper-io/zasper/internal/models"
+)
 
-func SetUpActiveSessions() map[string]models.SessionModel {
-	return make(map[string]models.SessionModel)
+const (
+	SessionEventCreated = "created"
+	SessionEventDeleted = "deleted"
+)
+
+// SessionEvent is pushed to registry subscribers when a session changes.
+type SessionEvent struct {
+	Type    string              `json:"type"`
+	Session models.SessionModel `json:"session"`
+}
+
+// SessionRegistry is a concurrency safe collection of sessions.
+type SessionRegistry struct {
+	mu       sync.RWMutex
+	sessions map[string]*models.SessionModel
+	events   *events.Hub[SessionEvent]
+}
+
+var ZasperSession *SessionRegistry
+
+func SetUpActiveSessions() *SessionRegistry {
+	return NewSessionRegistry()
+}
+
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{
+		sessions: make(map[string]*models.SessionModel),
+		events:   events.NewHub[SessionEvent](),
+	}
+}
+
+func (r *SessionRegistry) Add(session models.SessionModel) {
+	r.mu.Lock()
+	r.sessions[session.Id] = &session
+	r.mu.Unlock()
+
+	r.events.Publish(SessionEvent{Type: SessionEventCreated, Session: session})
+}
+
+// Get returns a copy of the session with the given id.
+func (r *SessionRegistry) Get(sessionId string) (models.SessionModel, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	session, ok := r.sessions[sessionId]
+	if !ok {
+		return models.SessionModel{}, false
+	}
+	return *session, true
+}
+
+func (r *SessionRegistry) Remove(sessionId string) (models.SessionModel, bool) {
+	r.mu.Lock()
+	session, ok := r.sessions[sessionId]
+	delete(r.sessions, sessionId)
+	r.mu.Unlock()
+
+	if !ok {
+		return models.SessionModel{}, false
+	}
+	r.events.Publish(SessionEvent{Type: SessionEventDeleted, Session: *session})
+	return *session, true
+}
+
+// List returns a snapshot of all sessions keyed by id.
+func (r *SessionRegistry) List() map[string]models.SessionModel {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sessions := make(map[string]models.SessionModel, len(r.sessions))
+	for id, session := range r.sessions {
+		sessions[id] = *session
+	}
+	return sessions
+}
+
+// Subscribe returns a channel of session events and a function releasing it.
+func (r *SessionRegistry) Subscribe() (<-chan SessionEvent, func()) {
+	return r.events.Subscribe()
 }
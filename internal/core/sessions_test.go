@@ -0,0 +1,61 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zasper-io/zasper/internal/models"
+)
+
+func TestSessionRegistryConcurrentAccess(t *testing.T) {
+	registry := NewSessionRegistry()
+	events, unsubscribe := registry.Subscribe()
+	defer unsubscribe()
+
+	const sessionCount = 20
+	var wg sync.WaitGroup
+
+	for i := 0; i < sessionCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("session-%d", i)
+			registry.Add(models.SessionModel{Id: id, Path: fmt.Sprintf("nb-%d.ipynb", i), Kernel: models.KernelModel{ExecutionState: "idle"}})
+			registry.List()
+		}(i)
+	}
+	wg.Wait()
+
+	sessions := registry.List()
+	assert.Len(t, sessions, sessionCount)
+	for _, session := range sessions {
+		assert.Equal(t, "idle", session.Kernel.ExecutionState)
+	}
+
+	for i := 0; i < sessionCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, ok := registry.Remove(fmt.Sprintf("session-%d", i))
+			assert.True(t, ok)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Empty(t, registry.List())
+	assert.Len(t, events, 2*sessionCount)
+}
+
+func TestSessionRegistryGetReturnsCopy(t *testing.T) {
+	registry := NewSessionRegistry()
+	registry.Add(models.SessionModel{Id: "a", Name: "original"})
+
+	session, ok := registry.Get("a")
+	assert.True(t, ok)
+	session.Name = "changed"
+
+	stored, _ := registry.Get("a")
+	assert.Equal(t, "original", stored.Name)
+}
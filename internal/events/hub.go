@@ -0,0 +1,53 @@
+package events
+
+import (
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+const subscriberBufferSize = 64
+
+// Hub fans events out to any number of subscribers. Publishing never blocks:
+// events are dropped for subscribers that are not keeping up.
+type Hub[E any] struct {
+	mu          sync.Mutex
+	subscribers map[chan E]struct{}
+}
+
+func NewHub[E any]() *Hub[E] {
+	return &Hub[E]{subscribers: make(map[chan E]struct{})}
+}
+
+// Subscribe registers a new listener. The returned function must be called to
+// release the subscription, it closes the channel.
+func (h *Hub[E]) Subscribe() (<-chan E, func()) {
+	ch := make(chan E, subscriberBufferSize)
+
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[ch]; ok {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
+
+func (h *Hub[E]) Publish(event E) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Warn().Msgf("dropping event %+v: subscriber is not keeping up", event)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	zhttp "github.com/zasper-io/zasper/internal/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+func sendGatewayError(w http.ResponseWriter, err error) {
+	log.Error().Msgf("%v", err)
+	zhttp.SendErrorResponse(w, ErrorStatus(err), err.Error())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(v)
+}
+
+func KernelspecAPIHandler(w http.ResponseWriter, req *http.Request) {
+	specs, err := Gateway.Kernelspecs()
+	if err != nil {
+		sendGatewayError(w, err)
+		return
+	}
+	writeJSON(w, specs)
+}
+
+func SingleKernelspecAPIHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	spec, err := Gateway.Kernelspec(vars["kernelName"])
+	if err != nil {
+		sendGatewayError(w, err)
+		return
+	}
+	writeJSON(w, spec)
+}
+
+// ServeKernelResource proxies the logos of the kernelspecs of the gateway.
+func ServeKernelResource(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	resp, err := Gateway.KernelspecResource(vars["kernel"], vars["resource"])
+	if err != nil {
+		sendGatewayError(w, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func KernelListAPIHandler(w http.ResponseWriter, req *http.Request) {
+	kernels, err := Gateway.ListKernels()
+	if err != nil {
+		sendGatewayError(w, err)
+		return
+	}
+	writeJSON(w, kernels)
+}
+
+func KernelReadAPIHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	kernel, err := Gateway.GetKernel(vars["kernelId"])
+	if err != nil {
+		sendGatewayError(w, err)
+		return
+	}
+	writeJSON(w, kernel)
+}
+
+func KernelInterruptAPIHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	if err := Gateway.InterruptKernel(vars["kernelId"]); err != nil {
+		sendGatewayError(w, err)
+		return
+	}
+	writeJSON(w, map[string]string{
+		"message": "Kernel interrupted successfully",
+	})
+}
+
+func KernelRestartAPIHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	kernel, err := Gateway.RestartKernel(vars["kernelId"])
+	if err != nil {
+		sendGatewayError(w, err)
+		return
+	}
+	writeJSON(w, kernel)
+}
+
+// KernelKillAPIHandler shuts a kernel down, for both the kernel API and the
+// DELETE on the websocket router.
+func KernelKillAPIHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	kernelId := vars["kernelId"]
+	if kernelId == "" {
+		kernelId = vars["kernel_id"]
+	}
+	if err := Gateway.ShutdownKernel(kernelId); err != nil {
+		sendGatewayError(w, err)
+		return
+	}
+	writeJSON(w, map[string]string{
+		"message": "Kernel killed successfully",
+	})
+}
+
+// NotSupportedAPIHandler answers the endpoints that need the kernels or the
+// kernelspecs to be local.
+func NotSupportedAPIHandler(w http.ResponseWriter, req *http.Request) {
+	zhttp.SendErrorResponse(w, http.StatusNotImplemented, fmt.Sprintf("%s %s is not available in gateway mode", req.Method, req.URL.Path))
+}
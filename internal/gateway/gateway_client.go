@@ -0,0 +1,293 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zasper-io/zasper/internal/kernelspec"
+	"github.com/zasper-io/zasper/internal/models"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+// RequestTimeout bounds every request made to the gateway. Starting a kernel
+// on a remote cluster can be slow, so it is generous.
+var RequestTimeout = 2 * time.Minute
+
+// Gateway is the Jupyter Kernel Gateway or Enterprise Gateway the kernels are
+// run on, or nil when kernels are run locally.
+var Gateway *Client
+
+// Enabled reports whether Zasper runs in gateway mode.
+func Enabled() bool {
+	return Gateway != nil
+}
+
+// Client talks to the REST and websocket API of a Jupyter Kernel Gateway.
+type Client struct {
+	URL    *url.URL
+	Token  string
+	HTTP   *http.Client
+	Dialer *websocket.Dialer
+
+	mu      sync.Mutex
+	started map[string]bool
+}
+
+// Error is an error response of the gateway.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("gateway returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("gateway returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// ErrorStatus is the status to answer a client with when a request to the
+// gateway failed: the status of client errors is kept, anything else is a
+// bad gateway.
+func ErrorStatus(err error) int {
+	var gwErr *Error
+	if errors.As(err, &gwErr) && gwErr.StatusCode >= 400 && gwErr.StatusCode < 500 {
+		return gwErr.StatusCode
+	}
+	return http.StatusBadGateway
+}
+
+func NewClient(rawURL string, token string) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(rawURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid gateway url %q, expected http(s)://host[:port][/path]", rawURL)
+	}
+	return &Client{
+		URL:     u,
+		Token:   token,
+		HTTP:    &http.Client{Timeout: RequestTimeout},
+		Dialer:  &websocket.Dialer{HandshakeTimeout: RequestTimeout},
+		started: map[string]bool{},
+	}, nil
+}
+
+// endpoint joins elem, escaped, to the base url of the gateway.
+func (c *Client) endpoint(elem ...string) *url.URL {
+	u := *c.URL
+	escaped := make([]string, len(elem))
+	for i, e := range elem {
+		escaped[i] = url.PathEscape(e)
+	}
+	u.Path = strings.TrimSuffix(c.URL.Path, "/") + "/" + strings.Join(elem, "/")
+	u.RawPath = strings.TrimSuffix(c.URL.EscapedPath(), "/") + "/" + strings.Join(escaped, "/")
+	return &u
+}
+
+func (c *Client) header() http.Header {
+	header := http.Header{}
+	if c.Token != "" {
+		header.Set("Authorization", "token "+c.Token)
+	}
+	return header
+}
+
+func (c *Client) request(method string, u *url.URL, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.header()
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	log.Debug().Msgf("gateway request %s %s", method, u.Path)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("gateway request failed: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, readError(resp)
+	}
+	return resp, nil
+}
+
+// do sends a JSON request to the API of the gateway and decodes the response
+// into out, if it is not nil.
+func (c *Client) do(method string, out interface{}, body interface{}, elem ...string) error {
+	resp, err := c.request(method, c.endpoint(append([]string{"api"}, elem...)...), body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid gateway response: %w", err)
+	}
+	return nil
+}
+
+func readError(resp *http.Response) error {
+	gwErr := &Error{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var body struct {
+		Message string `json:"message"`
+		Reason  string `json:"reason"`
+	}
+	if json.Unmarshal(data, &body) == nil {
+		gwErr.Message = body.Message
+		if gwErr.Message == "" {
+			gwErr.Message = body.Reason
+		}
+	} else {
+		gwErr.Message = strings.TrimSpace(string(data))
+	}
+	return gwErr
+}
+
+// gatewayKernelspec is a kernelspec as listed by the gateway, whose resources
+// are urls on the gateway.
+type gatewayKernelspec struct {
+	Name      string                        `json:"name"`
+	Spec      kernelspec.KernelSpecJsonData `json:"spec"`
+	Resources map[string]string             `json:"resources"`
+}
+
+// model points the resources of the kernelspec to the resource proxy.
+func (spec gatewayKernelspec) model() kernelspec.KernelspecModel {
+	resources := map[string]string{}
+	for name, resource := range spec.Resources {
+		resources[name] = path.Join("/static/kernelspecs", spec.Name, path.Base(resource))
+	}
+	return kernelspec.KernelspecModel{Name: spec.Name, Spec: spec.Spec, Resources: resources}
+}
+
+func (c *Client) Kernelspecs() (kernelspec.KernelspecResponse, error) {
+	var body struct {
+		Default     string                       `json:"default"`
+		Kernelspecs map[string]gatewayKernelspec `json:"kernelspecs"`
+	}
+	if err := c.do(http.MethodGet, &body, nil, "kernelspecs"); err != nil {
+		return kernelspec.KernelspecResponse{}, err
+	}
+	response := kernelspec.KernelspecResponse{
+		Default:    body.Default,
+		Kernespecs: make(map[string]kernelspec.KernelspecModel),
+	}
+	for name, spec := range body.Kernelspecs {
+		response.Kernespecs[name] = spec.model()
+	}
+	return response, nil
+}
+
+func (c *Client) Kernelspec(name string) (kernelspec.KernelspecModel, error) {
+	var spec gatewayKernelspec
+	if err := c.do(http.MethodGet, &spec, nil, "kernelspecs", name); err != nil {
+		return kernelspec.KernelspecModel{}, err
+	}
+	return spec.model(), nil
+}
+
+// KernelspecResource fetches a resource file of a kernelspec, like its logo.
+// The caller closes the body of the response.
+func (c *Client) KernelspecResource(name string, resource string) (*http.Response, error) {
+	return c.request(http.MethodGet, c.endpoint("kernelspecs", name, resource), nil)
+}
+
+// StartKernel starts a kernel on the gateway. The gateway decides which of the
+// environment variables are passed on to the kernel.
+func (c *Client) StartKernel(name string, env map[string]string) (models.KernelModel, error) {
+	var kernel models.KernelModel
+	body := map[string]interface{}{"name": name, "env": env}
+	if err := c.do(http.MethodPost, &kernel, body, "kernels"); err != nil {
+		return kernel, err
+	}
+	c.mu.Lock()
+	c.started[kernel.Id] = true
+	c.mu.Unlock()
+	log.Info().Msgf("started kernel %s (%s) on the gateway", kernel.Id, name)
+	return kernel, nil
+}
+
+func (c *Client) ListKernels() ([]models.KernelModel, error) {
+	kernels := []models.KernelModel{}
+	err := c.do(http.MethodGet, &kernels, nil, "kernels")
+	return kernels, err
+}
+
+func (c *Client) GetKernel(kernelId string) (models.KernelModel, error) {
+	var kernel models.KernelModel
+	err := c.do(http.MethodGet, &kernel, nil, "kernels", kernelId)
+	return kernel, err
+}
+
+func (c *Client) InterruptKernel(kernelId string) error {
+	return c.do(http.MethodPost, nil, nil, "kernels", kernelId, "interrupt")
+}
+
+func (c *Client) RestartKernel(kernelId string) (models.KernelModel, error) {
+	var kernel models.KernelModel
+	err := c.do(http.MethodPost, &kernel, nil, "kernels", kernelId, "restart")
+	return kernel, err
+}
+
+func (c *Client) ShutdownKernel(kernelId string) error {
+	c.mu.Lock()
+	delete(c.started, kernelId)
+	c.mu.Unlock()
+	return c.do(http.MethodDelete, nil, nil, "kernels", kernelId)
+}
+
+// Cleanup shuts down the kernels this server started on the gateway, which
+// are shared with other users.
+func (c *Client) Cleanup() {
+	c.mu.Lock()
+	kernelIds := make([]string, 0, len(c.started))
+	for kernelId := range c.started {
+		kernelIds = append(kernelIds, kernelId)
+	}
+	c.mu.Unlock()
+
+	for _, kernelId := range kernelIds {
+		if err := c.ShutdownKernel(kernelId); err != nil {
+			log.Error().Msgf("Error stopping kernel %s on the gateway: %v", kernelId, err)
+		}
+	}
+}
+
+// channelsURL is the websocket url of the channels of a kernel.
+func (c *Client) channelsURL(kernelId string, query url.Values) *url.URL {
+	u := c.endpoint("api", "kernels", kernelId, "channels")
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	u.RawQuery = query.Encode()
+	return u
+}
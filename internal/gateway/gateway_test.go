@@ -0,0 +1,229 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zasper-io/zasper/internal/core"
+	"github.com/zasper-io/zasper/internal/models"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "secret"
+
+// stubGateway implements the parts of the Kernel Gateway API used by Zasper.
+// Its channels echo every message back.
+type stubGateway struct {
+	mu      sync.Mutex
+	kernels map[string]models.KernelModel
+	started []map[string]interface{}
+}
+
+func newStubGateway(t *testing.T) (*stubGateway, *httptest.Server) {
+	gw := &stubGateway{kernels: map[string]models.KernelModel{}}
+	router := mux.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "token "+testToken {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	gw.routes(router.PathPrefix("/gw").Subrouter())
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return gw, server
+}
+
+func (gw *stubGateway) routes(router *mux.Router) {
+	router.HandleFunc("/api/kernelspecs", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"default": "python3", "kernelspecs": {"python3": {
+			"name": "python3",
+			"spec": {"argv": ["python"], "display_name": "Python 3", "language": "python"},
+			"resources": {"logo-64x64": "/gw/kernelspecs/python3/logo-64x64.png"}}}}`)
+	})
+	router.HandleFunc("/kernelspecs/{kernel}/{resource}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["resource"] != "logo-64x64.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, "png")
+	})
+	router.HandleFunc("/api/kernels", func(w http.ResponseWriter, r *http.Request) {
+		gw.mu.Lock()
+		defer gw.mu.Unlock()
+		if r.Method == http.MethodPost {
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["name"] != "python3" {
+				w.WriteHeader(http.StatusNotFound)
+				io.WriteString(w, `{"reason": "Not Found", "message": "No such kernel"}`)
+				return
+			}
+			gw.started = append(gw.started, body)
+			kernel := models.KernelModel{Id: "k1", Name: "python3", ExecutionState: "starting"}
+			gw.kernels[kernel.Id] = kernel
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(kernel)
+			return
+		}
+		kernels := []models.KernelModel{}
+		for _, kernel := range gw.kernels {
+			kernels = append(kernels, kernel)
+		}
+		json.NewEncoder(w).Encode(kernels)
+	})
+	router.HandleFunc("/api/kernels/{id}", func(w http.ResponseWriter, r *http.Request) {
+		gw.mu.Lock()
+		defer gw.mu.Unlock()
+		kernel, ok := gw.kernels[mux.Vars(r)["id"]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(gw.kernels, kernel.Id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(kernel)
+	})
+	router.HandleFunc("/api/kernels/{id}/interrupt", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.HandleFunc("/api/kernels/{id}/channels", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("session_id") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, data)
+		}
+	})
+}
+
+func setUpGateway(t *testing.T) *stubGateway {
+	gw, server := newStubGateway(t)
+	client, err := NewClient(server.URL+"/gw/", testToken)
+	require.NoError(t, err)
+	Gateway = client
+	t.Cleanup(func() { Gateway = nil })
+	return gw
+}
+
+func TestNewClientRejectsInvalidURL(t *testing.T) {
+	for _, rawURL := range []string{"localhost:8888", "ftp://host", "http://"} {
+		_, err := NewClient(rawURL, "")
+		assert.Error(t, err, rawURL)
+	}
+}
+
+func TestKernelspecs(t *testing.T) {
+	setUpGateway(t)
+
+	specs, err := Gateway.Kernelspecs()
+	require.NoError(t, err)
+	assert.Equal(t, "python3", specs.Default)
+	require.Contains(t, specs.Kernespecs, "python3")
+	assert.Equal(t, "Python 3", specs.Kernespecs["python3"].Spec.DisplayName)
+	assert.Equal(t, map[string]string{"logo-64x64": "/static/kernelspecs/python3/logo-64x64.png"}, specs.Kernespecs["python3"].Resources)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/static/kernelspecs/{kernel}/{resource}", ServeKernelResource)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/static/kernelspecs/python3/logo-64x64.png", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "png", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/static/kernelspecs/python3/logo-32x32.png", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestKernelLifecycle(t *testing.T) {
+	gw := setUpGateway(t)
+
+	_, err := Gateway.StartKernel("julia", nil)
+	var gwErr *Error
+	require.ErrorAs(t, err, &gwErr)
+	assert.Equal(t, http.StatusNotFound, ErrorStatus(err))
+	assert.Equal(t, "No such kernel", gwErr.Message)
+
+	kernel, err := Gateway.StartKernel("python3", map[string]string{"JPY_SESSION_NAME": "nb.ipynb"})
+	require.NoError(t, err)
+	assert.Equal(t, "k1", kernel.Id)
+	assert.Equal(t, map[string]interface{}{"JPY_SESSION_NAME": "nb.ipynb"}, gw.started[0]["env"])
+
+	kernels, err := Gateway.ListKernels()
+	require.NoError(t, err)
+	assert.Len(t, kernels, 1)
+
+	kernel, err = Gateway.GetKernel("k1")
+	require.NoError(t, err)
+	assert.Equal(t, "python3", kernel.Name)
+	require.NoError(t, Gateway.InterruptKernel("k1"))
+
+	Gateway.Cleanup()
+	_, err = Gateway.GetKernel("k1")
+	assert.Equal(t, http.StatusNotFound, ErrorStatus(err))
+}
+
+func TestUnreachableGateway(t *testing.T) {
+	client, err := NewClient("http://127.0.0.1:1", "")
+	require.NoError(t, err)
+	Gateway = client
+	defer func() { Gateway = nil }()
+
+	rec := httptest.NewRecorder()
+	KernelListAPIHandler(rec, httptest.NewRequest(http.MethodGet, "/api/kernels", nil))
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+}
+
+func TestChannelsAreRelayed(t *testing.T) {
+	setUpGateway(t)
+	core.ZasperSession = core.NewSessionRegistry()
+	core.ZasperSession.Add(models.SessionModel{Id: "s1"})
+
+	router := mux.NewRouter()
+	router.HandleFunc("/ws/kernels/{kernelId}/channels", HandleWebSocket)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/kernels/k1/channels"
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?session_id=unknown", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?session_id=s1", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	msg := `{"channel": "shell", "header": {"msg_type": "execute_request"}}`
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(msg)))
+	messageType, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, websocket.TextMessage, messageType)
+	assert.JSONEq(t, msg, string(data))
+}
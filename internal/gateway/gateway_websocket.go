@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/zasper-io/zasper/internal/core"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// HandleWebSocket connects the client to the channels of a kernel on the
+// gateway and relays the messages both ways as they are. Zasper and the
+// gateway speak the same JSON websocket protocol.
+func HandleWebSocket(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	kernelId := vars["kernelId"]
+	sessionId := req.URL.Query().Get("session_id")
+
+	if _, ok := core.ZasperSession.Get(sessionId); !ok {
+		log.Warn().Msg("session not found")
+		http.NotFound(w, req)
+		return
+	}
+
+	// dial first, so that the client gets a proper error if the gateway
+	// refuses the connection
+	gwConn, resp, err := Gateway.Dialer.Dial(Gateway.channelsURL(kernelId, req.URL.Query()).String(), Gateway.header())
+	if err != nil {
+		if resp != nil {
+			defer resp.Body.Close()
+			err = readError(resp)
+		}
+		sendGatewayError(w, err)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		log.Error().Msgf("%s", err)
+		gwConn.Close()
+		return
+	}
+	log.Debug().Msgf("relaying channels of kernel %s to the gateway", kernelId)
+
+	go func() {
+		var waiter sync.WaitGroup
+		waiter.Add(2)
+		go relay(conn, gwConn, &waiter)
+		go relay(gwConn, conn, &waiter)
+		waiter.Wait()
+		log.Debug().Msgf("stopped relaying channels of kernel %s", kernelId)
+	}()
+}
+
+// relay copies the messages of src to dst until either side closes, and then
+// closes both.
+func relay(src *websocket.Conn, dst *websocket.Conn, waiter *sync.WaitGroup) {
+	defer waiter.Done()
+	defer src.Close()
+	defer dst.Close()
+	for {
+		messageType, data, err := src.ReadMessage()
+		if err != nil {
+			code := websocket.CloseNormalClosure
+			if closeErr, ok := err.(*websocket.CloseError); ok && sendableCloseCode(closeErr.Code) {
+				code = closeErr.Code
+			}
+			dst.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""))
+			return
+		}
+		if err := dst.WriteMessage(messageType, data); err != nil {
+			log.Debug().Msgf("Error relaying message: %s", err)
+			return
+		}
+	}
+}
+
+// sendableCloseCode reports whether code may be sent in a close message, as
+// opposed to the codes which only report a connection closed abnormally.
+func sendableCloseCode(code int) bool {
+	switch code {
+	case websocket.CloseNoStatusReceived, websocket.CloseAbnormalClosure, websocket.CloseTLSHandshake:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package kernel
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-zeromq/zmq4"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	ExecutionStateStarting = "starting"
+	ExecutionStateIdle     = "idle"
+	ExecutionStateBusy     = "busy"
+	ExecutionStateDead     = "dead"
+	// ExecutionStateUnresponsive is set by the heartbeat monitoring, a
+	// kernel that answers again gets back to idle.
+	ExecutionStateUnresponsive = "unresponsive"
+)
+
+// watchActivity subscribes to the kernel's iopub channel for as long as the
+// kernel lives, independently of any websocket client, so that execution
+// state and last activity are always up to date, and buffers its messages
+// while a client is away. It also monitors the heartbeat of the kernel.
+func (km *KernelManager) watchActivity() context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	cinfo := km.ConnectionInfo
+	session := km.Session
+	kernelId := km.KernelId
+
+	go km.watchHeartbeat(ctx, Heartbeat)
+	go func() {
+		// the kernel may still be binding its ports, keep dialing until it answers
+		socket := cinfo.ConnectIopub(ctx, zmq4.WithDialerMaxRetries(-1))
+		defer socket.Close()
+
+		for {
+			zmsg, err := socket.Recv()
+			if err != nil {
+				if ctx.Err() != nil {
+					log.Debug().Msgf("activity watcher for kernel %s stopped", kernelId)
+					return
+				}
+				log.Debug().Msgf("activity watcher could not receive message: %v", err)
+				continue
+			}
+			msg := session.deserializeMessage(zmsg, "iopub")
+			recordActivity(kernelId, msg)
+			km.bufferMessage(msg)
+		}
+	}()
+	return cancel
+}
+
+// recordActivity updates the last activity timestamp of a kernel and, for
+// iopub status messages, its execution state.
+func recordActivity(kernelId string, msg Message) {
+	executionState := ""
+	if msg.Header.MsgType == "status" {
+		if content, ok := msg.Content.(map[string]interface{}); ok {
+			executionState, _ = content["execution_state"].(string)
+		}
+	}
+
+	km, ok := ZasperActiveKernels.Get(kernelId)
+	if !ok {
+		return
+	}
+
+	km.mu.Lock()
+	km.LastActivity = time.Now().UTC()
+	changed := executionState != "" && executionState != km.ExecutionState
+	if changed {
+		km.ExecutionState = executionState
+	}
+	if executionState == ExecutionStateIdle && km.pendingInput != nil && km.pendingInput.parentId == msg.ParentHeader.MsgID {
+		// the execution which asked for input is over, interrupted or not
+		km.clearPendingInputLocked()
+	}
+	km.mu.Unlock()
+
+	if changed {
+		log.Debug().Msgf("kernel %s is %s", kernelId, executionState)
+		ZasperActiveKernels.publishStatus(km)
+	}
+}
+
+func (km *KernelManager) statusEvent() KernelEvent {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	return KernelEvent{
+		Type:           KernelEventStatus,
+		KernelId:       km.KernelId,
+		ExecutionState: km.ExecutionState,
+		Reason:         km.Reason,
+		LastActivity:   formatActivity(km.LastActivity),
+		Connections:    km.Connections,
+	}
+}
+
+func formatActivity(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
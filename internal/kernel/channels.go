@@ -21,19 +21,20 @@ type KernelWebSocketConnection struct {
 	Conn                 *websocket.Conn
 	Send                 chan []byte
 	KernelId             string
-	KernelManager        KernelManager
+	KernelManager        *KernelManager
 	Context              context.Context
 	PollingCancel        context.CancelFunc
 	Channels             map[string]zmq4.Socket
 	Session              KernelSession
 	IOPubWindowMsgCount  int
 	IOPubWindowByteCount int
-	IOPubMsgsExceeded    int
-	IOPubDataExceeded    int
-	IOPubWindowByteQueue []interface{}
+	IOPubMsgsExceeded    bool
+	IOPubDataExceeded    bool
+	IOPubWindowByteQueue []iopubWindowEntry
 	KernelInfoChannel    zmq4.Socket
 	Subprotocol          string
 	mu                   sync.Mutex
+	sessionId            string
 }
 
 func (kwsConn *KernelWebSocketConnection) stopPolling() {
@@ -49,7 +50,10 @@ func (kwsConn *KernelWebSocketConnection) stopPolling() {
 	}
 }
 
-func (kwsConn *KernelWebSocketConnection) pollChannel(socket zmq4.Socket, socketName string) {
+// pollChannel forwards the messages of a kernel channel to the client. On
+// iopub, the messages the client missed while it was away are replayed
+// first.
+func (kwsConn *KernelWebSocketConnection) pollChannel(socket zmq4.Socket, socketName string, replay [][]byte) {
 	kwsConn.mu.Lock()
 	kwsConn.pollingWait.Add(1)
 	kwsConn.mu.Unlock()
@@ -60,6 +64,22 @@ func (kwsConn *KernelWebSocketConnection) pollChannel(socket zmq4.Socket, socket
 			kwsConn.pollingWait.Done()
 			kwsConn.mu.Unlock()
 		}()
+
+		// the channel was subscribed before the buffering stopped, the
+		// messages received meanwhile are both replayed and on the channel
+		replayed := map[string]bool{}
+		for _, data := range replay {
+			var msg Message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				log.Error().Msgf("Error unmarshaling buffered message: %v", err)
+				continue
+			}
+			replayed[msg.Header.MsgID] = true
+			if !kwsConn.forwardIOPub(msg, data) {
+				return
+			}
+		}
+
 		for {
 			select {
 			case <-kwsConn.Context.Done(): // Check if context is canceled
@@ -75,37 +95,86 @@ func (kwsConn *KernelWebSocketConnection) pollChannel(socket zmq4.Socket, socket
 				}
 				log.Debug().Msgf("channel: [%s] [%s] %s\n", socketName, zmsg.Frames[0], zmsg.Frames[1])
 
-				kwsConn.Send <- kwsConn.Session.Deserialize(zmsg, socketName)
+				msg := kwsConn.Session.deserializeMessage(zmsg, socketName)
+				if socketName == "iopub" && replayed[msg.Header.MsgID] {
+					delete(replayed, msg.Header.MsgID)
+					continue
+				}
+				data, err := json.Marshal(msg)
+				if err != nil {
+					log.Error().Msgf("Error marshaling message: %v", err)
+					continue
+				}
+				if msg.Header.MsgType == "input_request" {
+					kwsConn.KernelManager.trackInputRequest(msg, data)
+				}
+				if socketName == "iopub" {
+					if !kwsConn.forwardIOPub(msg, data) {
+						return
+					}
+					continue
+				}
+				if !kwsConn.send(data) {
+					return
+				}
 			}
 		}
 	}()
 }
 
-func (kwsConn *KernelWebSocketConnection) startPolling() { //msg interface{}, binary bool
+// forwardIOPub sends an iopub message to the client within the rate limits,
+// and returns false if the connection was closed meanwhile.
+func (kwsConn *KernelWebSocketConnection) forwardIOPub(msg Message, data []byte) bool {
+	forward, warning := kwsConn.limitIOPub(msg, len(data), time.Now())
+	if warning != nil && !kwsConn.send(warning) {
+		return false
+	}
+	if !forward {
+		return true
+	}
+	return kwsConn.send(data)
+}
+
+// send queues a message for the client, and returns false if the connection
+// was closed meanwhile.
+func (kwsConn *KernelWebSocketConnection) send(data []byte) bool {
+	select {
+	case kwsConn.Send <- data:
+		return true
+	case <-kwsConn.Context.Done():
+		return false
+	}
+}
+
+func (kwsConn *KernelWebSocketConnection) startPolling(replay [][]byte) { //msg interface{}, binary bool
 	iopub_channel := kwsConn.Channels["iopub"]
 	stdin_channel := kwsConn.Channels["stdin"]
 	control_channel := kwsConn.Channels["control"]
 	shell_channel := kwsConn.Channels["shell"]
 
-	kwsConn.pollChannel(iopub_channel, "iopub")
-	kwsConn.pollChannel(control_channel, "control")
-	kwsConn.pollChannel(stdin_channel, "stdin")
-	kwsConn.pollChannel(shell_channel, "shell")
+	kwsConn.pollChannel(iopub_channel, "iopub", replay)
+	kwsConn.pollChannel(control_channel, "control", nil)
+	kwsConn.pollChannel(stdin_channel, "stdin", nil)
+	kwsConn.pollChannel(shell_channel, "shell", nil)
 }
 
 func (kwsConn *KernelWebSocketConnection) Prepare(sessionId string) {
-	km := kwsConn.KernelManager
-	if km.Ready {
-		log.Debug().Msgf("%s", km.Session.Key)
-	} else {
-		log.Debug().Msg("Kernel is not ready")
-	}
-	kwsConn.Session = km.Session
+	kwsConn.Session = kwsConn.KernelManager.Session
+	kwsConn.sessionId = sessionId
 }
 
-func (kwsConn *KernelWebSocketConnection) Connect() {
+// Connect waits for the kernel to finish starting, then wires the websocket
+// to the kernel channels. If the kernel failed to start, the client receives
+// a dead status carrying the reason and an error is returned.
+func (kwsConn *KernelWebSocketConnection) Connect() error {
+	log.Debug().Msg("waiting for kernel to be ready")
+	if err := kwsConn.KernelManager.WaitForReady(kwsConn.Context); err != nil {
+		kwsConn.sendStatus(ExecutionStateDead, err.Error())
+		return err
+	}
+
 	log.Debug().Msg("notifying connection")
-	NotifyConnect()
+	NotifyConnect(kwsConn.KernelId)
 
 	log.Debug().Msg("creating stream")
 	kwsConn.createStream()
@@ -113,16 +182,80 @@ func (kwsConn *KernelWebSocketConnection) Connect() {
 	log.Debug().Msg("Nudging the kernel")
 	kwsConn.nudge()
 
+	// the iopub channel is subscribed now, what the client missed while it
+	// was away is replayed before the new messages
+	replay := kwsConn.KernelManager.stopBuffering(kwsConn.sessionId)
+
 	log.Debug().Msg("Start polling")
 	// subscribe
 	log.Info().Msg("Kernel launched successfully")
-	kwsConn.startPolling()
+	kwsConn.startPolling(replay)
+	go kwsConn.forwardHeartbeat()
+
+	// the kernel may be blocked on an input() asked to a client now gone
+	if data := kwsConn.KernelManager.pendingInputRequest(); data != nil {
+		log.Debug().Msg("replaying pending input request")
+		kwsConn.writeMessage(data)
+	}
+	return nil
+}
+
+// forwardHeartbeat tells the client when the kernel stops answering its
+// heartbeat, and when it answers again, since the kernel cannot.
+func (kwsConn *KernelWebSocketConnection) forwardHeartbeat() {
+	events, unsubscribe := ZasperActiveKernels.Subscribe()
+	defer unsubscribe()
+
+	unresponsive := false
+	for {
+		select {
+		case <-kwsConn.Context.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.KernelId != kwsConn.KernelId || event.Type != KernelEventStatus {
+				continue
+			}
+			if (event.ExecutionState == ExecutionStateUnresponsive) != unresponsive {
+				unresponsive = !unresponsive
+				kwsConn.sendStatus(event.ExecutionState, event.Reason)
+			}
+		}
+	}
+}
+
+// sendStatus writes a synthetic iopub status message straight to the client.
+func (kwsConn *KernelWebSocketConnection) sendStatus(executionState string, reason string) {
+	msg := kwsConn.Session.MessageFromString("status")
+	msg.Channel = "iopub"
+	msg.Content = map[string]interface{}{
+		"execution_state": executionState,
+		"reason":          reason,
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Error().Msgf("Error marshaling status message: %v", err)
+		return
+	}
+	kwsConn.writeMessage(data)
+}
+
+// writeMessage writes a message straight to the client, before the messages
+// of the kernel channels.
+func (kwsConn *KernelWebSocketConnection) writeMessage(data []byte) {
+	kwsConn.mu.Lock()
+	defer kwsConn.mu.Unlock()
+	if err := kwsConn.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		log.Info().Msgf("Error writing message: %s", err)
+	}
 }
 
 func (kwsConn *KernelWebSocketConnection) createStream() {
 
-	// connect on iopub, shell, control, stdin
-	// not sure about hb
+	// connect on iopub, shell, control, stdin; the heartbeat is monitored
+	// once per kernel by its manager
 	id := zmq4.SocketIdentity(fmt.Sprintf("channel-%s", uuid.New().String()))
 	cinfo := kwsConn.KernelManager.ConnectionInfo
 	context := kwsConn.Context
@@ -130,7 +263,6 @@ func (kwsConn *KernelWebSocketConnection) createStream() {
 	kwsConn.Channels["shell"] = cinfo.ConnectShell(context, id)
 	kwsConn.Channels["control"] = cinfo.ConnectControl(context)
 	kwsConn.Channels["stdin"] = cinfo.ConnectStdin(context, id)
-	kwsConn.Channels["hb"] = cinfo.ConnectHb(context)
 }
 
 func (kwsConn *KernelWebSocketConnection) nudge() {
@@ -201,10 +333,14 @@ func (kwsConn *KernelWebSocketConnection) handleIncomingMessage(incomingMsg []by
 			log.Info().Msgf("Error unmarshalling message: %s", err)
 			return
 		}
-		log.Debug().Msgf("msg is => %v", msg)
 		if msg.Channel == "stdin" {
+			// not logged, input replies may carry passwords
 			kwsConn.Session.SendStreamMsg(kwsConn.Channels["stdin"], msg)
+			if msg.Header.MsgType == "input_reply" {
+				kwsConn.KernelManager.inputAnswered()
+			}
 		} else {
+			log.Debug().Msgf("msg is => %v", msg)
 			kwsConn.Session.SendStreamMsg(kwsConn.Channels["shell"], msg)
 		}
 
@@ -215,6 +351,9 @@ func (kwsConn *KernelWebSocketConnection) ReadMessagesFromClient(waiter *sync.Wa
 	defer func() {
 		log.Info().Msg("Closing readMessagesFromClient")
 		kwsConn.Conn.Close()
+		kwsConn.stopPolling()
+		kwsConn.KernelManager.startBuffering(kwsConn.sessionId)
+		NotifyDisconnect(kwsConn.KernelId)
 		waiter.Done()
 	}()
 
@@ -254,11 +393,12 @@ func (kwsConn *KernelWebSocketConnection) WriteMessages(waiter *sync.WaitGroup)
 				return
 			}
 			kwsConn.mu.Lock()
-			if err := kwsConn.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			err := kwsConn.Conn.WriteMessage(websocket.TextMessage, message)
+			kwsConn.mu.Unlock()
+			if err != nil {
 				log.Info().Msgf("Error writing message: %s", err)
 				return
 			}
-			kwsConn.mu.Unlock()
 		}
 	}
 }
@@ -3,9 +3,11 @@ package kernel
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-
+	"io/fs"
 	"os"
+	"path/filepath"
 
 	"github.com/go-zeromq/zmq4"
 	"github.com/rs/zerolog/log"
@@ -43,20 +45,15 @@ type ConnectionFileData struct {
 	KernelName      string `json:"kernel_name"`
 }
 
-func (km *KernelManager) writeConnectionFile(connectionFile string) error {
-	// Open the file for writing, create it if it doesn't exist, or truncate it if it does.
-	file, err := os.Create(connectionFile)
-	log.Debug().Msgf("writing connection info to %s", file.Name())
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
-	}
-	defer file.Close()
-
-	// Create a JSON encoder and set indentation for pretty-printing.
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "    ")
+// KernelConnection is the connection file of a kernel and its path, for
+// other clients like jupyter console to attach to the kernel.
+type KernelConnection struct {
+	ConnectionFileData
+	ConnectionFile string `json:"connection_file"`
+}
 
-	data := ConnectionFileData{
+func (km *KernelManager) connectionFileData() ConnectionFileData {
+	return ConnectionFileData{
 		Transport:       km.ConnectionInfo.Transport,
 		IP:              km.ConnectionInfo.IP,
 		Key:             km.Session.Key,
@@ -68,15 +65,58 @@ func (km *KernelManager) writeConnectionFile(connectionFile string) error {
 		SignatureScheme: km.Session.SignatureScheme,
 		KernelName:      km.KernelName,
 	}
+}
+
+// writeConnectionFile writes the connection file, which holds the key signing
+// the messages of the kernel, readable by the user only.
+func (km *KernelManager) writeConnectionFile(connectionFile string) error {
+	if err := os.MkdirAll(filepath.Dir(connectionFile), 0700); err != nil {
+		return fmt.Errorf("failed to create the runtime directory: %w", err)
+	}
+	file, err := os.OpenFile(connectionFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer file.Close()
+	log.Debug().Msgf("writing connection info to %s", file.Name())
+	// an existing file keeps its permissions
+	if err := file.Chmod(0600); err != nil {
+		return fmt.Errorf("failed to restrict the permissions of %s: %w", connectionFile, err)
+	}
+
+	// Create a JSON encoder and set indentation for pretty-printing.
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "    ")
 
 	// Encode the data as JSON and write it to the file.
-	if err := encoder.Encode(data); err != nil {
+	if err := encoder.Encode(km.connectionFileData()); err != nil {
 		return fmt.Errorf("failed to encode JSON: %w", err)
 	}
 
 	return nil
 }
 
+// readConnectionFile reads the connection file of a kernel.
+func readConnectionFile(connectionFile string) (ConnectionFileData, error) {
+	var data ConnectionFileData
+	content, err := os.ReadFile(connectionFile)
+	if err != nil {
+		return data, err
+	}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return data, fmt.Errorf("%w: %s: %v", ErrInvalidConnectionInfo, connectionFile, err)
+	}
+	return data, nil
+}
+
+// removeConnectionFile removes the connection file of a kernel which has been
+// shut down.
+func (km *KernelManager) removeConnectionFile() {
+	if err := os.Remove(km.ConnectionFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Warn().Msgf("cannot remove connection file %s: %v", km.ConnectionFile, err)
+	}
+}
+
 /*********************************************************************
 **********************************************************************
 ***                  Create Connected Sockets                      ***
@@ -88,7 +128,50 @@ func (conn *Connection) makeURL(channel string, port int) string {
 	if conn.Transport == "tcp" {
 		return fmt.Sprintf("tcp://%s:%d", conn.IP, port)
 	}
-	return fmt.Sprintf("%s://%s-%d", conn.Transport, conn.IP, port)
+	return fmt.Sprintf("%s://%s", conn.Transport, conn.ipcPath(port))
+}
+
+// ipcPath is the path of the unix socket of port with the ipc transport, where
+// IP is the common prefix of the sockets of the kernel.
+func (conn *Connection) ipcPath(port int) string {
+	return fmt.Sprintf("%s-%d", conn.IP, port)
+}
+
+func (conn *Connection) ports() []int {
+	return []int{conn.ShellPort, conn.IopubPort, conn.StdinPort, conn.HbPort, conn.ControlPort}
+}
+
+// maxSocketPath is the longest path of a unix socket on every platform.
+const maxSocketPath = 103
+
+// prepareIPC creates the directory of the sockets of the kernel, and
+// numbers the sockets. The directory is readable by its user only before the
+// kernel starts, even if it was left over, so that the sockets are never
+// reachable by other users.
+func (conn *Connection) prepareIPC() error {
+	dir := filepath.Dir(conn.IP)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("cannot create the directory of the kernel sockets: %w", err)
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return fmt.Errorf("cannot restrict the directory of the kernel sockets: %w", err)
+	}
+	conn.ShellPort, conn.IopubPort, conn.StdinPort, conn.HbPort, conn.ControlPort = 1, 2, 3, 4, 5
+	if path := conn.ipcPath(conn.ControlPort); len(path) > maxSocketPath {
+		return fmt.Errorf("socket path %s is too long, set JUPYTER_RUNTIME_DIR to a shorter directory", path)
+	}
+	return nil
+}
+
+// removeIPC removes the sockets, and their directory, of a kernel which has been shut down.
+func (conn *Connection) removeIPC() {
+	if conn.Transport != "ipc" {
+		return
+	}
+	for _, port := range conn.ports() {
+		os.Remove(conn.ipcPath(port))
+	}
+	os.Remove(filepath.Dir(conn.IP))
 }
 
 func (conn *Connection) ConnectShell(ctx context.Context, id zmq4.SocketIdentity) zmq4.Socket {
@@ -114,11 +197,11 @@ func (conn *Connection) ConnectControl(ctx context.Context) zmq4.Socket {
 	return socket
 }
 
-func (conn *Connection) ConnectIopub(ctx context.Context) zmq4.Socket {
+func (conn *Connection) ConnectIopub(ctx context.Context, opts ...zmq4.Option) zmq4.Socket {
 	channel := "iopub"
 
 	url := conn.makeURL(channel, conn.IopubPort)
-	socket := zmq4.NewSub(ctx)
+	socket := zmq4.NewSub(ctx, opts...)
 	err := socket.SetOption(zmq4.OptionSubscribe, "")
 	if err != nil {
 		log.Error().Msgf("could not subscribe: %v", err)
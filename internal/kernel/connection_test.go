@@ -0,0 +1,103 @@
+package kernel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zasper-io/zasper/internal/core"
+)
+
+func TestIPCTransport(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the ipc transport needs unix sockets")
+	}
+	setUpFakeKernels(t, newFakeProvisioner)
+	KernelTransport = "ipc"
+	defer func() { KernelTransport = "tcp" }()
+	core.Zasper.JupyterRuntimeDir = filepath.Join(t.TempDir(), "runtime")
+	require.NoError(t, os.MkdirAll(core.Zasper.JupyterRuntimeDir, 0755))
+
+	kernelId, err := StartKernelManager("", "fake", nil)
+	require.NoError(t, err)
+	require.NoError(t, waitForKernel(t, kernelId))
+	km, _ := ZasperActiveKernels.Get(kernelId)
+
+	data, err := os.ReadFile(km.ConnectionFile)
+	require.NoError(t, err)
+	var cinfo ConnectionFileData
+	require.NoError(t, json.Unmarshal(data, &cinfo))
+	assert.Equal(t, "ipc", cinfo.Transport)
+	socketDir := filepath.Join(core.Zasper.JupyterRuntimeDir, "kernel-"+kernelId+"-ipc")
+	assert.Equal(t, filepath.Join(socketDir, "kernel"), cinfo.IP)
+	assert.Equal(t, 1, cinfo.ShellPort)
+
+	// the sockets are only reachable by the user of the server
+	info, err := os.Stat(socketDir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	shellSocket := cinfo.IP + "-1"
+	info, err = os.Stat(shellSocket)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSocket, info.Mode().Type())
+
+	require.NoError(t, KillKernelById(kernelId))
+	assert.NoDirExists(t, socketDir)
+}
+
+func TestPrepareIPCRestrictsExistingDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the ipc transport needs unix sockets")
+	}
+	dir := filepath.Join(t.TempDir(), "kernel-1-ipc")
+	require.NoError(t, os.Mkdir(dir, 0755))
+
+	conn := &Connection{Transport: "ipc", IP: filepath.Join(dir, "kernel")}
+	require.NoError(t, conn.prepareIPC())
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+}
+
+func TestConnectionFile(t *testing.T) {
+	setUpFakeKernels(t, newFakeProvisioner)
+
+	kernelId, err := StartKernelManager("", "fake", nil)
+	require.NoError(t, err)
+	require.NoError(t, waitForKernel(t, kernelId))
+	km, _ := ZasperActiveKernels.Get(kernelId)
+
+	connectionFile := filepath.Join(core.Zasper.JupyterRuntimeDir, "kernel-"+kernelId+".json")
+	assert.Equal(t, connectionFile, km.ConnectionFile)
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(connectionFile)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/kernels/{kernelId}/connection", KernelConnectionAPIHandler)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/kernels/"+kernelId+"/connection", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var connection KernelConnection
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&connection))
+	assert.Equal(t, connectionFile, connection.ConnectionFile)
+	assert.Equal(t, km.Session.Key, connection.Key)
+	assert.Equal(t, km.ConnectionInfo.ShellPort, connection.ShellPort)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/kernels/does-not-exist/connection", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	require.NoError(t, KillKernelById(kernelId))
+	assert.NoFileExists(t, connectionFile)
+}
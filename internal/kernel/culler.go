@@ -0,0 +1,90 @@
+package kernel
+
+import (
+	"context"
+	"time"
+
+	"github.com/zasper-io/zasper/internal/core"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CullerConfig controls the shutdown of idle kernels. A zero IdleTimeout
+// disables culling.
+type CullerConfig struct {
+	IdleTimeout   time.Duration
+	Interval      time.Duration
+	CullBusy      bool
+	CullConnected bool
+}
+
+// StartCuller periodically shuts down kernels that have been idle for longer
+// than the configured timeout, until ctx is canceled.
+func StartCuller(ctx context.Context, config CullerConfig) {
+	if config.IdleTimeout <= 0 {
+		log.Debug().Msg("kernel culling disabled")
+		return
+	}
+	if config.Interval <= 0 {
+		config.Interval = 5 * time.Minute
+	}
+	log.Info().Msgf("Culling kernels idle for more than %s, checking every %s (cull busy: %t, cull connected: %t)",
+		config.IdleTimeout, config.Interval, config.CullBusy, config.CullConnected)
+
+	go func() {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				cullKernels(config)
+			}
+		}
+	}()
+}
+
+func cullKernels(config CullerConfig) {
+	now := time.Now().UTC()
+
+	for _, km := range ZasperActiveKernels.List() {
+		if !shouldCull(km, now, config) {
+			continue
+		}
+		event := km.statusEvent()
+		log.Info().Msgf("Culling '%s' kernel '%s' (%s) with %d connections, last active at %s.",
+			event.ExecutionState, km.KernelName, km.KernelId, event.Connections, event.LastActivity)
+		if err := KillKernelById(km.KernelId); err != nil {
+			log.Error().Msgf("Failed to cull kernel %s: %v", km.KernelId, err)
+			continue
+		}
+		removeKernelSessions(km.KernelId)
+	}
+}
+
+// removeKernelSessions removes the sessions of a culled kernel, which would
+// otherwise keep listing it.
+func removeKernelSessions(kernelId string) {
+	if core.ZasperSession == nil {
+		return
+	}
+	for id, session := range core.ZasperSession.List() {
+		if session.Kernel.Id == kernelId {
+			log.Info().Msgf("Removing session %s of culled kernel %s", id, kernelId)
+			core.ZasperSession.Remove(id)
+		}
+	}
+}
+
+func shouldCull(km *KernelManager, now time.Time, config CullerConfig) bool {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	if km.LastActivity.IsZero() {
+		return false
+	}
+	isIdleTime := now.Sub(km.LastActivity) > config.IdleTimeout
+	isIdleExecute := config.CullBusy || km.ExecutionState != ExecutionStateBusy
+	isIdleConnected := config.CullConnected || km.Connections == 0
+	return isIdleTime && isIdleExecute && isIdleConnected
+}
@@ -0,0 +1,116 @@
+package kernel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zasper-io/zasper/internal/core"
+	"github.com/zasper-io/zasper/internal/models"
+)
+
+func TestShouldCull(t *testing.T) {
+	now := time.Now().UTC()
+	timeout := 10 * time.Minute
+
+	tests := []struct {
+		name     string
+		km       *KernelManager
+		config   CullerConfig
+		expected bool
+	}{
+		{
+			name:     "Recently active kernel is kept",
+			km:       &KernelManager{LastActivity: now.Add(-time.Minute), ExecutionState: ExecutionStateIdle},
+			config:   CullerConfig{IdleTimeout: timeout},
+			expected: false,
+		},
+		{
+			name:     "Idle kernel is culled",
+			km:       &KernelManager{LastActivity: now.Add(-time.Hour), ExecutionState: ExecutionStateIdle},
+			config:   CullerConfig{IdleTimeout: timeout},
+			expected: true,
+		},
+		{
+			name:     "Busy kernel is kept",
+			km:       &KernelManager{LastActivity: now.Add(-time.Hour), ExecutionState: ExecutionStateBusy},
+			config:   CullerConfig{IdleTimeout: timeout},
+			expected: false,
+		},
+		{
+			name:     "Busy kernel is culled with cull busy",
+			km:       &KernelManager{LastActivity: now.Add(-time.Hour), ExecutionState: ExecutionStateBusy},
+			config:   CullerConfig{IdleTimeout: timeout, CullBusy: true},
+			expected: true,
+		},
+		{
+			name:     "Connected kernel is kept",
+			km:       &KernelManager{LastActivity: now.Add(-time.Hour), ExecutionState: ExecutionStateIdle, Connections: 1},
+			config:   CullerConfig{IdleTimeout: timeout},
+			expected: false,
+		},
+		{
+			name:     "Connected kernel is culled with cull connected",
+			km:       &KernelManager{LastActivity: now.Add(-time.Hour), ExecutionState: ExecutionStateIdle, Connections: 1},
+			config:   CullerConfig{IdleTimeout: timeout, CullConnected: true},
+			expected: true,
+		},
+		{
+			name:     "Kernel without activity is kept",
+			km:       &KernelManager{ExecutionState: ExecutionStateIdle},
+			config:   CullerConfig{IdleTimeout: timeout},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, shouldCull(tt.km, now, tt.config))
+		})
+	}
+}
+
+func TestCullKernels(t *testing.T) {
+	now := time.Now().UTC()
+	config := CullerConfig{IdleTimeout: 10 * time.Minute}
+
+	tests := []struct {
+		name        string
+		km          *KernelManager
+		culled      bool
+		sessionKept bool
+	}{
+		{
+			name:   "Session of a culled kernel is removed",
+			km:     &KernelManager{KernelId: "idle", LastActivity: now.Add(-time.Hour), ExecutionState: ExecutionStateIdle},
+			culled: true,
+		},
+		{
+			name:        "Session of a kept kernel is kept",
+			km:          &KernelManager{KernelId: "active", LastActivity: now, ExecutionState: ExecutionStateIdle},
+			sessionKept: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ZasperActiveKernels = NewKernelRegistry()
+			ZasperPendingKernels = NewKernelRegistry()
+			core.ZasperSession = core.NewSessionRegistry()
+			defer func() { core.ZasperSession = nil }()
+
+			ZasperActiveKernels.Add(tt.km)
+			core.ZasperSession.Add(models.SessionModel{Id: "session", Kernel: models.KernelModel{Id: tt.km.KernelId}})
+			core.ZasperSession.Add(models.SessionModel{Id: "other", Kernel: models.KernelModel{Id: "other"}})
+
+			cullKernels(config)
+
+			_, active := ZasperActiveKernels.Get(tt.km.KernelId)
+			assert.Equal(t, !tt.culled, active)
+			_, kept := core.ZasperSession.Get("session")
+			assert.Equal(t, tt.sessionKept, kept)
+			_, kept = core.ZasperSession.Get("other")
+			assert.True(t, kept)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package kernel
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/zasper-io/zasper/internal/core"
+	"github.com/zasper-io/zasper/internal/kernelspec"
+
+	"github.com/rs/zerolog/log"
+)
+
+// kernelVars returns the variables a kernel gets on top of the environment
+// it runs in. Later sources override earlier ones:
+//
+//  1. the env of the kernelspec, where ${VAR} is replaced by its value in the
+//     environment of the server, as jupyter_client does: kernelspec vars
+//     cannot reference each other, which would depend on map order
+//  2. the .env file at the root of the project
+//  3. the variables of the session, like JPY_SESSION_NAME
+//
+// The kernelspec vars are returned apart from the others, for remote
+// kernels which expand them in their own environment.
+func (km *KernelManager) kernelVars(spec kernelspec.KernelSpecJsonData) (specVars, sessionVars *envMap) {
+	base := newEnvMap(os.Environ())
+	specVars = newEnvMap(nil)
+	for name, value := range spec.Env {
+		specVars.set(name, base.expand(value))
+	}
+
+	sessionVars = newEnvMap(nil)
+	dotenv := filepath.Join(core.Zasper.HomeDir, ".env")
+	vars, err := readDotenv(dotenv)
+	if err != nil && !os.IsNotExist(err) {
+		log.Warn().Msgf("ignoring %s: %v", dotenv, err)
+	}
+	// .env values see the kernelspec vars and the previous lines
+	scope := base
+	scope.update(specVars)
+	for _, v := range vars {
+		value := scope.expand(v[1])
+		scope.set(v[0], value)
+		sessionVars.set(v[0], value)
+	}
+
+	for name, value := range km.sessionEnv {
+		sessionVars.set(name, value)
+	}
+	return specVars, sessionVars
+}
+
+// kernelEnv builds the environment of a kernel process run by the server:
+// the environment of the server, the kernelVars and JPY_PARENT_PID.
+func kernelEnv(specVars, sessionVars *envMap) []string {
+	env := newEnvMap(os.Environ())
+	env.update(specVars)
+	env.update(sessionVars)
+	env.set("JPY_PARENT_PID", strconv.Itoa(os.Getpid()))
+	return env.environ()
+}
+
+// envMap holds environment variables, keeping the case of their names but
+// comparing them case-insensitively on Windows.
+type envMap struct {
+	names  map[string]string
+	values map[string]string
+}
+
+func newEnvMap(environ []string) *envMap {
+	env := &envMap{names: map[string]string{}, values: map[string]string{}}
+	for _, kv := range environ {
+		if name, value, ok := strings.Cut(kv, "="); ok && name != "" {
+			env.set(name, value)
+		}
+	}
+	return env
+}
+
+func envKey(name string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToUpper(name)
+	}
+	return name
+}
+
+func (env *envMap) set(name, value string) {
+	key := envKey(name)
+	if _, ok := env.names[key]; !ok {
+		env.names[key] = name
+	}
+	env.values[key] = value
+}
+
+// update sets the variables of other in env.
+func (env *envMap) update(other *envMap) {
+	for key, value := range other.values {
+		env.set(other.names[key], value)
+	}
+}
+
+func (env *envMap) get(name string) (string, bool) {
+	value, ok := env.values[envKey(name)]
+	return value, ok
+}
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expand replaces ${VAR} by the value of VAR, leaving unknown variables as
+// they are.
+func (env *envMap) expand(value string) string {
+	return envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		if v, ok := env.get(ref[2 : len(ref)-1]); ok {
+			return v
+		}
+		return ref
+	})
+}
+
+func (env *envMap) environ() []string {
+	environ := []string{}
+	for key, value := range env.values {
+		environ = append(environ, env.names[key]+"="+value)
+	}
+	slices.Sort(environ)
+	return environ
+}
+
+// readDotenv parses a .env file made of NAME=value lines. Values may be
+// quoted, and lines may start with "export" or be # comments.
+func readDotenv(path string) ([][2]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	vars := [][2]string{}
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("line %d: expected NAME=value", lineNo)
+		}
+		vars = append(vars, [2]string{name, parseDotenvValue(strings.TrimSpace(value))})
+	}
+	return vars, scanner.Err()
+}
+
+func parseDotenvValue(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			return unquoted
+		}
+		return value[1 : len(value)-1]
+	}
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return value[1 : len(value)-1]
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value
+}
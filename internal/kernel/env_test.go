@@ -0,0 +1,88 @@
+package kernel
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zasper-io/zasper/internal/core"
+	"github.com/zasper-io/zasper/internal/kernelspec"
+)
+
+func TestReadDotenv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	content := `# database
+export DB_HOST=localhost
+DB_PASSWORD="s3cret # not a comment"
+GREETING='hello ${USER}'
+DEBUG=1 # enable debugging
+MULTILINE="a\nb"
+`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	vars, err := readDotenv(path)
+	require.NoError(t, err)
+	assert.Equal(t, [][2]string{
+		{"DB_HOST", "localhost"},
+		{"DB_PASSWORD", "s3cret # not a comment"},
+		{"GREETING", "hello ${USER}"},
+		{"DEBUG", "1"},
+		{"MULTILINE", "a\nb"},
+	}, vars)
+
+	require.NoError(t, os.WriteFile(path, []byte("NOT AN ASSIGNMENT\n"), 0644))
+	_, err = readDotenv(path)
+	assert.EqualError(t, err, "line 1: expected NAME=value")
+}
+
+func TestKernelEnv(t *testing.T) {
+	project := t.TempDir()
+	core.Zasper.HomeDir = project
+	dotenv := "PROJECT_DATA=${PROJECT_ROOT}/data\nMODE=project\n"
+	require.NoError(t, os.WriteFile(filepath.Join(project, ".env"), []byte(dotenv), 0644))
+
+	t.Setenv("PROJECT_ROOT", "/srv/project")
+	t.Setenv("MODE", "server")
+	t.Setenv("PATH", "/usr/bin")
+
+	spec := kernelspec.KernelSpecJsonData{Env: map[string]string{
+		"PATH":           "/opt/conda/bin:${PATH}",
+		"MODE":           "kernelspec",
+		"UNKNOWN_VAR":    "${NOT_SET}",
+		"JPY_PARENT_PID": "0",
+		"SPEC_HOME":      "/opt/spec",
+		"SPEC_DATA":      "${SPEC_HOME}/data",
+		"SPEC_MODE":      "${MODE}",
+	}}
+	km := &KernelManager{sessionEnv: map[string]string{"JPY_SESSION_NAME": "/srv/project/notebook.ipynb"}}
+
+	specVars, sessionVars := km.kernelVars(spec)
+	env := newEnvMap(kernelEnv(specVars, sessionVars))
+
+	expected := map[string]string{
+		"PATH":         "/opt/conda/bin:/usr/bin",
+		"MODE":         "project",
+		"PROJECT_DATA": "/srv/project/data",
+		"UNKNOWN_VAR":  "${NOT_SET}",
+		// kernelspec vars only see the environment of the server
+		"SPEC_DATA":        "${SPEC_HOME}/data",
+		"SPEC_MODE":        "server",
+		"JPY_SESSION_NAME": "/srv/project/notebook.ipynb",
+		"JPY_PARENT_PID":   strconv.Itoa(os.Getpid()),
+	}
+	for name, value := range expected {
+		actual, ok := env.get(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, value, actual, name)
+	}
+
+	// what is forwarded to remote kernels, without the server environment
+	assert.Equal(t, []string{
+		"JPY_SESSION_NAME=/srv/project/notebook.ipynb",
+		"MODE=project",
+		"PROJECT_DATA=/srv/project/data",
+	}, sessionVars.environ())
+}
@@ -0,0 +1,18 @@
+package kernel
+
+const (
+	KernelEventAdded   = "added"
+	KernelEventRemoved = "removed"
+	KernelEventStatus  = "status"
+)
+
+// KernelEvent is pushed to registry subscribers when a kernel is added,
+// removed, or its observable state changes.
+type KernelEvent struct {
+	Type           string `json:"type"`
+	KernelId       string `json:"kernel_id"`
+	ExecutionState string `json:"execution_state"`
+	Reason         string `json:"reason,omitempty"`
+	LastActivity   string `json:"last_activity"`
+	Connections    int    `json:"connections"`
+}
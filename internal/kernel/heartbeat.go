@@ -0,0 +1,156 @@
+package kernel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-zeromq/zmq4"
+	"github.com/rs/zerolog/log"
+)
+
+// HeartbeatConfig controls how kernels are checked for being alive through
+// their hb channel, which echoes pings even while the kernel is busy.
+type HeartbeatConfig struct {
+	// Interval between pings; 0 disables heartbeat monitoring.
+	Interval time.Duration
+	// MaxMissed is the number of pings in a row a kernel may miss before it
+	// is considered unresponsive.
+	MaxMissed int
+	// Restart restarts unresponsive kernels.
+	Restart bool
+}
+
+var Heartbeat = HeartbeatConfig{
+	Interval:  3 * time.Second,
+	MaxMissed: 5,
+}
+
+// watchHeartbeat pings the kernel until ctx is done, and marks it unresponsive
+// after config.MaxMissed missed pings, or alive again after an answer.
+func (km *KernelManager) watchHeartbeat(ctx context.Context, config HeartbeatConfig) {
+	if config.Interval <= 0 {
+		return
+	}
+	p := &pinger{ctx: ctx, url: km.ConnectionInfo.makeURL("hb", km.ConnectionInfo.HbPort)}
+
+	// a ping is answered late rather than sent again, as the REQ socket
+	// waits for the reply anyway
+	var inFlight chan bool
+	defer func() {
+		if inFlight == nil {
+			p.close()
+		}
+	}()
+
+	missed := 0
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if state := km.executionState(); state == ExecutionStateStarting || state == ExecutionStateDead {
+			// its launch waits for it
+			missed = 0
+			continue
+		}
+
+		if inFlight == nil {
+			inFlight = make(chan bool, 1)
+			go func(result chan<- bool) { result <- p.ping() }(inFlight)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case ok := <-inFlight:
+			inFlight = nil
+			if ok {
+				missed = 0
+				km.markResponsive()
+				continue
+			}
+		case <-time.After(config.Interval):
+		}
+
+		missed++
+		log.Debug().Msgf("kernel %s missed %d heartbeats", km.KernelId, missed)
+		if missed == config.MaxMissed {
+			km.markUnresponsive(time.Duration(missed) * config.Interval)
+			if config.Restart {
+				go func() {
+					if err := km.Restart(); err != nil {
+						log.Error().Msgf("cannot restart unresponsive kernel %s: %v", km.KernelId, err)
+					}
+				}()
+				return
+			}
+		}
+	}
+}
+
+// pinger sends pings on the hb channel one at a time, dialing it when needed.
+type pinger struct {
+	ctx    context.Context
+	url    string
+	socket zmq4.Socket
+}
+
+func (p *pinger) ping() bool {
+	if p.socket == nil {
+		socket := zmq4.NewReq(p.ctx, zmq4.WithDialerMaxRetries(0))
+		if err := socket.Dial(p.url); err != nil {
+			log.Debug().Msgf("cannot dial heartbeat %s: %v", p.url, err)
+			socket.Close()
+			return false
+		}
+		p.socket = socket
+	}
+	err := p.socket.Send(zmq4.NewMsgString("ping"))
+	if err == nil {
+		_, err = p.socket.Recv()
+	}
+	if err != nil {
+		p.close()
+		return false
+	}
+	return true
+}
+
+func (p *pinger) close() {
+	if p.socket != nil {
+		p.socket.Close()
+		p.socket = nil
+	}
+}
+
+func (km *KernelManager) executionState() string {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	return km.ExecutionState
+}
+
+func (km *KernelManager) markUnresponsive(silence time.Duration) {
+	km.mu.Lock()
+	km.ExecutionState = ExecutionStateUnresponsive
+	km.Reason = fmt.Sprintf("kernel did not answer its heartbeat for %s", silence)
+	km.mu.Unlock()
+	log.Warn().Msgf("kernel %s is unresponsive", km.KernelId)
+	ZasperActiveKernels.publishStatus(km)
+}
+
+func (km *KernelManager) markResponsive() {
+	km.mu.Lock()
+	changed := km.ExecutionState == ExecutionStateUnresponsive
+	if changed {
+		km.ExecutionState = ExecutionStateIdle
+		km.Reason = ""
+	}
+	km.mu.Unlock()
+	if changed {
+		log.Info().Msgf("kernel %s answers its heartbeat again", km.KernelId)
+		ZasperActiveKernels.publishStatus(km)
+	}
+}
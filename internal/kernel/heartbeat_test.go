@@ -0,0 +1,87 @@
+package kernel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zasper-io/zasper/internal/kernel/provisioner"
+)
+
+// hungProvisioner starts a kernel which does not answer its heartbeat, and a
+// sane one when it is restarted.
+type hungProvisioner struct {
+	*fakeProvisioner
+}
+
+func (p *hungProvisioner) LaunchKernel(kernelCmd []string, kw map[string]interface{}, connFile string) (provisioner.KernelConnectionInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return provisioner.KernelConnectionInfo{}, p.launchLocked(kw, connFile, p.launches > 0)
+}
+
+func TestHeartbeat(t *testing.T) {
+	previous := Heartbeat
+	defer func() { Heartbeat = previous }()
+
+	tests := []struct {
+		name    string
+		hung    bool
+		restart bool
+	}{
+		{name: "alive"},
+		{name: "unresponsive", hung: true},
+		{name: "restarted", hung: true, restart: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Heartbeat = HeartbeatConfig{Interval: 20 * time.Millisecond, MaxMissed: 3, Restart: tt.restart}
+			provisioners := setUpFakeKernels(t, func() provisioner.Provisioner {
+				if tt.hung {
+					return &hungProvisioner{newFakeProvisioner()}
+				}
+				return newFakeProvisioner()
+			})
+			events, unsubscribe := ZasperActiveKernels.Subscribe()
+			defer unsubscribe()
+
+			kernelId, err := StartKernelManager("", "fake", nil)
+			require.NoError(t, err)
+			defer KillKernelById(kernelId)
+			require.NoError(t, waitForKernel(t, kernelId))
+
+			if !tt.hung {
+				time.Sleep(10 * Heartbeat.Interval)
+				model, _ := getKernel(kernelId)
+				assert.Equal(t, ExecutionStateIdle, model.ExecutionState)
+				return
+			}
+
+			waitForState := func(state string) KernelEvent {
+				timeout := time.After(5 * time.Second)
+				for {
+					select {
+					case event := <-events:
+						if event.KernelId == kernelId && event.Type == KernelEventStatus && event.ExecutionState == state {
+							return event
+						}
+					case <-timeout:
+						t.Fatalf("kernel did not become %s", state)
+					}
+				}
+			}
+			event := waitForState(ExecutionStateUnresponsive)
+			assert.Contains(t, event.Reason, "heartbeat")
+			if !tt.restart {
+				return
+			}
+
+			waitForState(ExecutionStateIdle)
+			p, _ := provisioners.Load(kernelId)
+			p.(*hungProvisioner).mu.Lock()
+			defer p.(*hungProvisioner).mu.Unlock()
+			assert.Equal(t, 2, p.(*hungProvisioner).launches)
+		})
+	}
+}
@@ -2,11 +2,17 @@ package kernel
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"net/http"
 
 	"github.com/rs/zerolog/log"
+	"github.com/zasper-io/zasper/internal/core"
 	zhttp "github.com/zasper-io/zasper/internal/http"
 
 	"github.com/gorilla/mux"
@@ -19,6 +25,14 @@ func KernelListAPIHandler(w http.ResponseWriter, req *http.Request) {
 		zhttp.SendErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error listing kernels: %v", err))
 		return
 	}
+	if withResources, _ := strconv.ParseBool(req.URL.Query().Get("resources")); withResources {
+		usage := listKernelResources()
+		for i := range kernels {
+			if resources, ok := usage[kernels[i].Id]; ok {
+				kernels[i].Resources = &resources
+			}
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(kernels)
@@ -30,6 +44,10 @@ func KernelReadAPIHandler(w http.ResponseWriter, req *http.Request) {
 	log.Debug().Msgf("kernelId : %s", kernelId)
 
 	kernel, err := getKernel(kernelId)
+	if errors.Is(err, ErrNoSuchKernel) {
+		zhttp.SendErrorResponse(w, http.StatusNotFound, fmt.Sprintf("kernel %s not found", kernelId))
+		return
+	}
 	if err != nil {
 		log.Error().Msgf("Error getting kernel: %v", err)
 		zhttp.SendErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error getting kernel: %v", err))
@@ -60,15 +78,40 @@ func KernelInterruptAPIHandler(w http.ResponseWriter, req *http.Request) {
 	})
 }
 
+func KernelRestartAPIHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	kernelId := vars["kernelId"]
+	log.Info().Msgf("kernelId : %s", kernelId)
+
+	if _, ok := GetKernelManager(kernelId); !ok {
+		zhttp.SendErrorResponse(w, http.StatusNotFound, fmt.Sprintf("kernel %s not found", kernelId))
+		return
+	}
+	kernel, err := restartKernel(kernelId)
+	if err != nil {
+		log.Error().Msgf("Error restarting kernel: %v", err)
+		zhttp.SendErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error restarting kernel: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(kernel)
+}
+
 func KernelKillAPIHandler(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	kernelId := vars["kernelId"]
 	log.Info().Msgf("kernelId : %s", kernelId)
 
 	err := KillKernelById(kernelId)
+	if errors.Is(err, ErrNoSuchKernel) {
+		zhttp.SendErrorResponse(w, http.StatusNotFound, fmt.Sprintf("kernel %s not found", kernelId))
+		return
+	}
 	if err != nil {
-		log.Error().Msgf("Error getting kernel: %v", err)
-		zhttp.SendErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error getting kernel: %v", err))
+		log.Error().Msgf("Error killing kernel: %v", err)
+		zhttp.SendErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error killing kernel: %v", err))
 		return
 	}
 
@@ -78,3 +121,167 @@ func KernelKillAPIHandler(w http.ResponseWriter, req *http.Request) {
 		"message": "Kernel killed successfully",
 	})
 }
+
+func KernelLogsAPIHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	kernelId := vars["kernelId"]
+
+	km, ok := GetKernelManager(kernelId)
+	if !ok {
+		zhttp.SendErrorResponse(w, http.StatusNotFound, fmt.Sprintf("kernel %s not found", kernelId))
+		return
+	}
+
+	lines := km.Logs.Lines()
+	if tail := req.URL.Query().Get("tail"); tail != "" {
+		n, err := strconv.Atoi(tail)
+		if err != nil || n < 0 {
+			zhttp.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid tail %q", tail))
+			return
+		}
+		lines = km.Logs.Tail(n, "")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(lines)
+}
+
+type attachRequest struct {
+	// ConnectionFile is the path of the connection file in the Jupyter
+	// runtime dir, usually relative to it as for jupyter console --existing.
+	ConnectionFile string `json:"connection_file"`
+	// ConnectionInfo is the content of a connection file.
+	ConnectionInfo *ConnectionFileData `json:"connection_info"`
+}
+
+// runtimeConnectionFile resolves the path of a connection file, which must be
+// in the Jupyter runtime dir: the files of the server are not readable through
+// the API. Kernels elsewhere are attached from their connection_info.
+func runtimeConnectionFile(name string) (string, error) {
+	runtimeDir, err := filepath.Abs(core.Zasper.JupyterRuntimeDir)
+	if err != nil {
+		return "", err
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(runtimeDir, path)
+	}
+	if !isInDir(filepath.Clean(path), runtimeDir) {
+		return "", fmt.Errorf("%s is not in %s", name, runtimeDir)
+	}
+	// symlinks must not lead out of the runtime dir either
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if resolvedDir, err := filepath.EvalSymlinks(runtimeDir); err != nil || !isInDir(resolved, resolvedDir) {
+		return "", fmt.Errorf("%s is not in %s", name, runtimeDir)
+	}
+	return resolved, nil
+}
+
+func isInDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// KernelAttachAPIHandler registers a kernel started outside of Zasper from
+// its connection file.
+func KernelAttachAPIHandler(w http.ResponseWriter, req *http.Request) {
+	var body attachRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		zhttp.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	var info ConnectionFileData
+	switch {
+	case body.ConnectionFile != "":
+		connectionFile, err := runtimeConnectionFile(body.ConnectionFile)
+		if errors.Is(err, fs.ErrNotExist) {
+			zhttp.SendErrorResponse(w, http.StatusNotFound, fmt.Sprintf("connection file %s not found", body.ConnectionFile))
+			return
+		}
+		if err == nil {
+			info, err = readConnectionFile(connectionFile)
+		}
+		if err != nil {
+			log.Debug().Msgf("cannot attach to %s: %v", body.ConnectionFile, err)
+			zhttp.SendErrorResponse(w, http.StatusBadRequest, "invalid connection file")
+			return
+		}
+		body.ConnectionFile = connectionFile
+	case body.ConnectionInfo != nil:
+		info = *body.ConnectionInfo
+	default:
+		zhttp.SendErrorResponse(w, http.StatusBadRequest, "connection_file or connection_info is required")
+		return
+	}
+
+	kernelId, err := AttachKernel(info, body.ConnectionFile)
+	if err != nil {
+		zhttp.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	kernel, err := getKernel(kernelId)
+	if err != nil {
+		zhttp.SendErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(kernel)
+}
+
+// KernelConnectionAPIHandler returns the connection info of a kernel, key
+// included, so that jupyter console or an IDE can attach to it.
+func KernelConnectionAPIHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	kernelId := vars["kernelId"]
+
+	km, ok := GetKernelManager(kernelId)
+	if !ok {
+		zhttp.SendErrorResponse(w, http.StatusNotFound, fmt.Sprintf("kernel %s not found", kernelId))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(KernelConnection{
+		ConnectionFileData: km.connectionFileData(),
+		ConnectionFile:     km.ConnectionFile,
+	})
+}
+
+func KernelResourcesAPIHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	kernelId := vars["kernelId"]
+
+	resources, err := getKernelResources(kernelId)
+	if err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, ErrResourcesUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		zhttp.SendErrorResponse(w, status, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resources)
+}
+
+func KernelResourcesListAPIHandler(w http.ResponseWriter, req *http.Request) {
+	usage := listKernelResources()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"kernels": usage,
+		"total":   totalResources(usage),
+	})
+}
@@ -0,0 +1,96 @@
+package kernel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zasper-io/zasper/internal/core"
+	"github.com/zasper-io/zasper/internal/kernel/provisioner"
+	"github.com/zasper-io/zasper/internal/models"
+)
+
+func TestKernelAPIHandlerUnknownKernel(t *testing.T) {
+	ZasperActiveKernels = NewKernelRegistry()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/kernels/{kernelId}", KernelReadAPIHandler).Methods("GET")
+	router.HandleFunc("/api/kernels/{kernelId}", KernelKillAPIHandler).Methods("DELETE")
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, "/api/kernels/does-not-exist", nil))
+
+		assert.Equal(t, http.StatusNotFound, rec.Code, method)
+		assert.Contains(t, rec.Body.String(), "kernel does-not-exist not found", method)
+	}
+}
+
+func TestAttachKernel(t *testing.T) {
+	provisioners := setUpFakeKernels(t, newFakeProvisioner)
+
+	// a kernel started outside of Zasper
+	externalId, err := StartKernelManager("", "fake", nil)
+	require.NoError(t, err)
+	defer KillKernelById(externalId)
+	require.NoError(t, waitForKernel(t, externalId))
+	external, _ := ZasperActiveKernels.Get(externalId)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/kernels/attach", KernelAttachAPIHandler)
+	attach := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/kernels/attach", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := attach(`{"connection_file": "` + filepath.Base(external.ConnectionFile) + `"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var model models.KernelModel
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&model))
+	assert.Equal(t, "fake", model.Name)
+	require.NoError(t, waitForKernel(t, model.Id))
+
+	km, _ := ZasperActiveKernels.Get(model.Id)
+	assert.False(t, km.OwnsKernel)
+	assert.Equal(t, external.Session.Key, km.Session.Key)
+	assert.NoError(t, km.Interrupt(), "attached kernels are interrupted with a message")
+	_, err = restartKernel(model.Id)
+	assert.ErrorIs(t, err, provisioner.ErrNotOwned)
+
+	// detaching leaves the kernel running
+	require.NoError(t, KillKernelById(model.Id))
+	assert.FileExists(t, external.ConnectionFile)
+	p, _ := provisioners.Load(externalId)
+	p.(*fakeProvisioner).mu.Lock()
+	assert.False(t, p.(*fakeProvisioner).shutdown)
+	p.(*fakeProvisioner).mu.Unlock()
+
+	assert.Equal(t, http.StatusNotFound, attach(`{"connection_file": "kernel-missing.json"}`).Code)
+
+	// only connection files in the runtime dir are read
+	outside := filepath.Join(t.TempDir(), "kernel-outside.json")
+	data, err := os.ReadFile(external.ConnectionFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(outside, data, 0600))
+	link := filepath.Join(core.Zasper.JupyterRuntimeDir, "kernel-link.json")
+	if runtime.GOOS != "windows" {
+		require.NoError(t, os.Symlink(outside, link))
+	}
+	for _, name := range []string{outside, "../" + filepath.Base(outside), link} {
+		rec := attach(`{"connection_file": ` + strconv.Quote(name) + `}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, name)
+		assert.Contains(t, rec.Body.String(), "invalid connection file", name)
+		assert.NotContains(t, rec.Body.String(), filepath.Dir(outside), name)
+	}
+	assert.Equal(t, http.StatusBadRequest, attach(`{"connection_info": {"ip": "127.0.0.1", "shell_port": 1}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, attach(`{}`).Code)
+}
@@ -0,0 +1,133 @@
+package kernel
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/zasper-io/zasper/internal/events"
+
+	"github.com/rs/zerolog/log"
+)
+
+// KernelLogLines is the number of output lines kept for each kernel.
+var KernelLogLines = 1000
+
+// maxLogLineLength bounds lines that never see a newline, like progress bars.
+const maxLogLineLength = 4096
+
+type LogEntry struct {
+	Time   string `json:"time"`
+	Stream string `json:"stream"`
+	Text   string `json:"text"`
+}
+
+// LogBuffer keeps the last lines written by a kernel process and streams new
+// ones to subscribers.
+type LogBuffer struct {
+	kernelId string
+
+	mu      sync.Mutex
+	entries []LogEntry
+	next    int
+	full    bool
+	events  *events.Hub[LogEntry]
+}
+
+func NewLogBuffer(kernelId string, size int) *LogBuffer {
+	return &LogBuffer{
+		kernelId: kernelId,
+		entries:  make([]LogEntry, size),
+		events:   events.NewHub[LogEntry](),
+	}
+}
+
+func (lb *LogBuffer) append(stream string, text string) {
+	entry := LogEntry{
+		Time:   time.Now().UTC().Format(time.RFC3339Nano),
+		Stream: stream,
+		Text:   text,
+	}
+	log.Debug().Msgf("kernel %s %s: %s", lb.kernelId, stream, text)
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.entries[lb.next] = entry
+	lb.next = (lb.next + 1) % len(lb.entries)
+	if lb.next == 0 {
+		lb.full = true
+	}
+	// published under the lock, for Follow
+	lb.events.Publish(entry)
+}
+
+// Lines returns the buffered lines, oldest first.
+func (lb *LogBuffer) Lines() []LogEntry {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.linesLocked()
+}
+
+func (lb *LogBuffer) linesLocked() []LogEntry {
+	if !lb.full {
+		return append([]LogEntry{}, lb.entries[:lb.next]...)
+	}
+	return append(append([]LogEntry{}, lb.entries[lb.next:]...), lb.entries[:lb.next]...)
+}
+
+// Tail returns at most n of the most recent lines of the given stream, or of
+// every stream when stream is empty.
+func (lb *LogBuffer) Tail(n int, stream string) []LogEntry {
+	lines := lb.Lines()
+	tail := []LogEntry{}
+	for i := len(lines) - 1; i >= 0 && len(tail) < n; i-- {
+		if stream == "" || lines[i].Stream == stream {
+			tail = append([]LogEntry{lines[i]}, tail...)
+		}
+	}
+	return tail
+}
+
+// Subscribe returns a channel of new log lines and a function releasing it.
+func (lb *LogBuffer) Subscribe() (<-chan LogEntry, func()) {
+	return lb.events.Subscribe()
+}
+
+// Follow returns the buffered lines, and subscribes to the lines after them:
+// each line is in exactly one of the two.
+func (lb *LogBuffer) Follow() ([]LogEntry, <-chan LogEntry, func()) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lines, unsubscribe := lb.events.Subscribe()
+	return lb.linesLocked(), lines, unsubscribe
+}
+
+// Writer returns an io.Writer that appends complete lines to the buffer,
+// labelled with stream.
+func (lb *LogBuffer) Writer(stream string) io.Writer {
+	return &logLineWriter{buffer: lb, stream: stream}
+}
+
+type logLineWriter struct {
+	buffer  *LogBuffer
+	stream  string
+	partial []byte
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.buffer.append(w.stream, string(bytes.TrimRight(w.partial[:i], "\r")))
+		w.partial = w.partial[i+1:]
+	}
+	if len(w.partial) > maxLogLineLength {
+		w.buffer.append(w.stream, string(w.partial))
+		w.partial = nil
+	}
+	return len(p), nil
+}
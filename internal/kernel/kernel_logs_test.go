@@ -0,0 +1,90 @@
+package kernel
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func logTexts(entries []LogEntry) []string {
+	texts := []string{}
+	for _, entry := range entries {
+		texts = append(texts, entry.Stream+":"+entry.Text)
+	}
+	return texts
+}
+
+func TestLogBufferSplitsLines(t *testing.T) {
+	lb := NewLogBuffer("k", 10)
+	stdout := lb.Writer("stdout")
+	stderr := lb.Writer("stderr")
+
+	fmt.Fprint(stdout, "hello ")
+	fmt.Fprint(stderr, "Traceback\r\n")
+	fmt.Fprint(stdout, "world\nsecond line\npartial")
+
+	assert.Equal(t, []string{"stderr:Traceback", "stdout:hello world", "stdout:second line"}, logTexts(lb.Lines()))
+	assert.Equal(t, []string{"stderr:Traceback"}, logTexts(lb.Tail(5, "stderr")))
+	assert.Equal(t, []string{"stdout:second line"}, logTexts(lb.Tail(1, "")))
+}
+
+func TestLogBufferKeepsMostRecentLines(t *testing.T) {
+	lb := NewLogBuffer("k", 3)
+	stdout := lb.Writer("stdout")
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(stdout, "line %d\n", i)
+	}
+
+	assert.Equal(t, []string{"stdout:line 2", "stdout:line 3", "stdout:line 4"}, logTexts(lb.Lines()))
+}
+
+func TestLogBufferBoundsLongLines(t *testing.T) {
+	lb := NewLogBuffer("k", 3)
+	fmt.Fprint(lb.Writer("stdout"), strings.Repeat("#", maxLogLineLength+1))
+
+	assert.Len(t, lb.Lines(), 1)
+}
+
+func TestLogBufferStreamsNewLines(t *testing.T) {
+	lb := NewLogBuffer("k", 3)
+	lines, unsubscribe := lb.Subscribe()
+	defer unsubscribe()
+
+	fmt.Fprint(lb.Writer("stderr"), "Segmentation fault\n")
+
+	entry := <-lines
+	assert.Equal(t, "stderr", entry.Stream)
+	assert.Equal(t, "Segmentation fault", entry.Text)
+}
+
+func TestLogBufferFollow(t *testing.T) {
+	// fewer lines than a subscription holds, so that none is dropped
+	const count = 60
+	lb := NewLogBuffer("k", 100)
+	writer := lb.Writer("stdout")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < count; i++ {
+			fmt.Fprintf(writer, "%d\n", i)
+		}
+	}()
+	backlog, lines, unsubscribe := lb.Follow()
+	defer unsubscribe()
+	<-done
+
+	seen := []string{}
+	for _, line := range backlog {
+		seen = append(seen, line.Text)
+	}
+	for len(seen) < count {
+		seen = append(seen, (<-lines).Text)
+	}
+	assert.Empty(t, lines)
+	for i, text := range seen {
+		assert.Equal(t, fmt.Sprint(i), text)
+	}
+}
@@ -1,9 +1,14 @@
 package kernel
 
 import (
-	"os"
+	"context"
+	"errors"
+	"fmt"
 	"os/exec"
 	"slices"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/zasper-io/zasper/internal/kernel/provisioner"
 	"github.com/zasper-io/zasper/internal/kernelspec"
@@ -14,6 +19,11 @@ import (
 )
 
 type KernelManager struct {
+	// mu guards LastActivity, ExecutionState, Reason, Connections,
+	// ShuttingDown, stopActivityWatcher, lastSample, kernelCmd, launchKw,
+	// pendingInput and replay
+	mu sync.Mutex
+
 	ConnectionFile string
 	OwnsKernel     bool
 	ShutdownStatus bool
@@ -22,18 +32,33 @@ type KernelManager struct {
 	KernelName     string
 	ControlSocket  zmq4.Socket
 	CachePorts     bool
-	Provisioner    provisioner.LocalProvisioner
+	Provisioner    provisioner.Provisioner
 	Kernelspec     string
 
-	LastActivity   string
+	LastActivity   time.Time
 	ExecutionState string
+	Reason         string
 	Connections    int
 
+	kernelCmd           []string
+	ports               *portReservation
+	launchKw            map[string]interface{}
+	stopActivityWatcher context.CancelFunc
+	ready               chan struct{}
+	readyOnce           sync.Once
+	interruptMode       string
+	sessionEnv          map[string]string
+	cwd                 string
+	lastSample          processSample
+	pendingInput        *pendingInput
+	replay              *replayBuffer
+
 	KernelId     string
 	ShuttingDown bool
 
 	Session        KernelSession
 	ConnectionInfo Connection
+	Logs           *LogBuffer
 }
 
 /*********************************************************************
@@ -47,39 +72,273 @@ func (km *KernelManager) StartKernel(kernelName string) error {
 
 	km.AttemptedStart = true
 
-	kernelCmd, kw := km.asyncPrestartKernel(kernelName)
-	err := km.LaunchKernel(kernelCmd, kw)
+	kernelCmd, kw, err := km.asyncPrestartKernel(kernelName)
 	if err != nil {
+		km.ports.unbind()
 		return err
 	}
-	km.Ready = true
+	return km.LaunchKernel(kernelCmd, kw)
+}
+
+// launchAttempts is how many times a kernel is launched on new ports when
+// another process took one of its ports before it could bind it.
+const launchAttempts = 3
+
+// startInBackground launches the kernel process and waits for it to answer a
+// kernel_info_request. The outcome is recorded on the manager and published
+// to the registry subscribers.
+func (km *KernelManager) startInBackground() {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = km.startAndWait()
+		if !isAddrInUse(err) || attempt == launchAttempts || km.isShuttingDown() {
+			break
+		}
+		log.Warn().Msgf("kernel %s lost a port before binding it, launching it on other ports: %v", km.KernelId, err)
+		km.mu.Lock()
+		stopActivityWatcher := km.stopActivityWatcher
+		km.stopActivityWatcher = nil
+		km.mu.Unlock()
+		if stopActivityWatcher != nil {
+			stopActivityWatcher()
+		}
+		releasePorts(km.KernelId)
+	}
+	ZasperPendingKernels.Remove(km.KernelId)
+
+	km.mu.Lock()
+	if err != nil {
+		log.Error().Msgf("kernel %s failed to start: %v", km.KernelId, err)
+		km.ExecutionState = ExecutionStateDead
+		km.Reason = err.Error()
+	} else {
+		log.Info().Msgf("kernel %s (%s) is ready", km.KernelId, km.KernelName)
+		km.Ready = true
+		if km.ExecutionState == ExecutionStateStarting {
+			km.ExecutionState = ExecutionStateIdle
+		}
+	}
+	km.LastActivity = time.Now().UTC()
+	km.mu.Unlock()
+
+	km.readyOnce.Do(func() { close(km.ready) })
+	ZasperActiveKernels.publishStatus(km)
+}
+
+// startAndWait launches the kernel, unless it was started outside of Zasper,
+// and waits for it to be ready.
+func (km *KernelManager) startAndWait() error {
+	if km.OwnsKernel {
+		if err := km.StartKernel(km.KernelName); err != nil {
+			return err
+		}
+	}
+	km.mu.Lock()
+	shuttingDown := km.ShuttingDown
+	if !shuttingDown {
+		km.stopActivityWatcher = km.watchActivity()
+	}
+	km.mu.Unlock()
+
+	if shuttingDown {
+		// the kernel was stopped while it was being launched
+		km.Provisioner.ShutdownKernel()
+		return errors.New("kernel was shut down during startup")
+	}
+	return km.waitForReady(KernelStartupTimeout)
+}
+
+// WaitForReady blocks until the kernel has started or failed to start, and
+// returns the reason of the failure.
+func (km *KernelManager) WaitForReady(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-km.ready:
+	}
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	if !km.Ready {
+		return errors.New(km.Reason)
+	}
 	return nil
 }
 
-func (km *KernelManager) StopKernel(kernelId string) {
+func (km *KernelManager) StopKernel(kernelId string) error {
+	km.mu.Lock()
 	km.ShuttingDown = true
-	km.Provisioner.ShutdownKernel()
+	stopActivityWatcher := km.stopActivityWatcher
+	km.mu.Unlock()
+
+	if stopActivityWatcher != nil {
+		stopActivityWatcher()
+	}
+	defer releasePorts(km.KernelId)
+	if km.OwnsKernel {
+		defer km.ConnectionInfo.removeIPC()
+		defer km.removeConnectionFile()
+	}
+	if km.Provisioner == nil {
+		return nil
+	}
+	return km.Provisioner.ShutdownKernel()
 }
 
-func (km *KernelManager) getKernelspec() kernelspec.KernelSpecJsonData {
-	return kernelspec.GetKernelSpec(km.KernelName)
+// interruptTimeout is how long a kernel has to answer an interrupt_request.
+const interruptTimeout = 5 * time.Second
+
+// Interrupt stops the code running in the kernel, either by sending SIGINT to
+// its process group or, when the kernelspec asks for it with interrupt_mode
+// "message", by sending an interrupt_request on the control channel.
+func (km *KernelManager) Interrupt() error {
+	if km.interruptMode == "message" {
+		return km.requestInterrupt(interruptTimeout)
+	}
+	return km.Provisioner.SignalKernel(syscall.SIGINT)
 }
 
-func (km *KernelManager) asyncPrestartKernel(kernelName string) ([]string, map[string]interface{}) {
-	km.ShuttingDown = false
+// Restart restarts the kernel, keeping its id, ports and connection file.
+// Provisioners which can restart the kernel themselves, like containers, do
+// so; otherwise the kernel is shut down and launched again.
+func (km *KernelManager) Restart() error {
+	if !km.OwnsKernel {
+		return fmt.Errorf("cannot restart kernel %s: %w", km.KernelId, provisioner.ErrNotOwned)
+	}
+	km.mu.Lock()
+	if km.ShuttingDown {
+		km.mu.Unlock()
+		return fmt.Errorf("kernel %s is shutting down", km.KernelId)
+	}
+	if km.kernelCmd == nil {
+		km.mu.Unlock()
+		return fmt.Errorf("kernel %s was never launched", km.KernelId)
+	}
+	kernelCmd, kw := km.kernelCmd, km.launchKw
+	stopActivityWatcher := km.stopActivityWatcher
+	km.stopActivityWatcher = nil
+	km.ExecutionState = ExecutionStateStarting
+	km.clearPendingInputLocked()
+	km.mu.Unlock()
+	ZasperActiveKernels.publishStatus(km)
+
+	if stopActivityWatcher != nil {
+		stopActivityWatcher()
+	}
+	log.Info().Msgf("restarting kernel %s", km.KernelId)
 
-	km.Provisioner = provisioner.LocalProvisioner{
-		KernelId:    km.KernelId,
-		Kernelspec:  km.getKernelspec(),
-		PortsCached: false,
+	var err error
+	if restarter, ok := km.Provisioner.(interface{ RestartKernel() error }); ok {
+		err = restarter.RestartKernel()
+	} else {
+		err = km.relaunch(kernelCmd, kw)
+	}
+	if err == nil {
+		km.mu.Lock()
+		shuttingDown := km.ShuttingDown
+		if !shuttingDown {
+			km.stopActivityWatcher = km.watchActivity()
+		}
+		km.mu.Unlock()
+
+		if shuttingDown {
+			// the kernel was stopped while it was being restarted
+			km.Provisioner.ShutdownKernel()
+			err = errors.New("kernel was shut down during restart")
+		} else {
+			err = km.waitForReady(KernelStartupTimeout)
+		}
 	}
 
-	log.Debug().Msgf("kernelspec created is: %v", km.Provisioner.Kernelspec)
+	km.mu.Lock()
+	if err != nil {
+		log.Error().Msgf("kernel %s failed to restart: %v", km.KernelId, err)
+		km.ExecutionState = ExecutionStateDead
+		km.Reason = err.Error()
+	} else {
+		km.ExecutionState = ExecutionStateIdle
+		km.Reason = ""
+	}
+	km.LastActivity = time.Now().UTC()
+	km.mu.Unlock()
+	ZasperActiveKernels.publishStatus(km)
+	return err
+}
+
+// relaunch shuts the kernel down and launches it again with the same command
+// and connection file.
+func (km *KernelManager) relaunch(kernelCmd []string, kw map[string]interface{}) error {
+	if err := km.Provisioner.ShutdownKernel(); err != nil {
+		return err
+	}
+	select {
+	case <-km.Provisioner.Exited():
+	case <-time.After(interruptTimeout):
+		return fmt.Errorf("kernel %s did not exit", km.KernelId)
+	}
+	_, err := km.Provisioner.LaunchKernel(kernelCmd, kw, km.ConnectionFile)
+	return err
+}
+
+func (km *KernelManager) requestInterrupt(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	control := km.ConnectionInfo.ConnectControl(ctx)
+	defer control.Close()
+
+	km.Session.SendStreamMsg(control, km.Session.MessageFromString("interrupt_request"))
+	if _, err := control.Recv(); err != nil {
+		return fmt.Errorf("no interrupt_reply from kernel %s: %w", km.KernelId, err)
+	}
+	return nil
+}
+
+func (km *KernelManager) isShuttingDown() bool {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	return km.ShuttingDown
+}
+
+func (km *KernelManager) getKernelspec() kernelspec.KernelSpecJsonData {
+	return kernelspec.GetKernelSpec(km.KernelName)
+}
 
-	kw := km.preLaunch()
+func (km *KernelManager) asyncPrestartKernel(kernelName string) ([]string, map[string]interface{}, error) {
+	kw, err := km.preLaunch()
+	if err != nil {
+		return nil, nil, err
+	}
 	kernelCmd := kw["cmd"].([]string)
 	log.Debug().Msgf("kenelName: %s", kernelName)
-	return kernelCmd, kw
+	return kernelCmd, kw, nil
+}
+
+// KernelResourceLimits apply to every kernel, unless its kernelspec sets its
+// own limits.
+var KernelResourceLimits provisioner.ResourceLimits
+
+// newProvisioner creates the provisioner responsible for the kernel process.
+var newProvisioner = func(km *KernelManager) provisioner.Provisioner {
+	kspec := km.getKernelspec()
+	log.Debug().Msgf("kernelspec created is: %v", kspec)
+	limits, err := provisioner.LimitsFromKernelspec(kspec)
+	if err != nil {
+		log.Warn().Msgf("ignoring resource limits of kernelspec %s: %v", km.KernelName, err)
+	}
+	switch name := provisioner.ProvisionerName(kspec); name {
+	case "ssh":
+		return provisioner.NewSSHProvisioner(km.KernelId, kspec, km.Logs.Writer("stdout"), km.Logs.Writer("stderr"))
+	case "docker", "podman":
+		return provisioner.NewContainerProvisioner(km.KernelId, name, kspec, KernelResourceLimits.Merge(limits), km.Logs.Writer("stdout"), km.Logs.Writer("stderr"))
+	}
+	return &provisioner.LocalProvisioner{
+		KernelId:    km.KernelId,
+		Kernelspec:  kspec,
+		PortsCached: false,
+		Stdout:      km.Logs.Writer("stdout"),
+		Stderr:      km.Logs.Writer("stderr"),
+		Limits:      KernelResourceLimits.Merge(limits),
+	}
 }
 
 var LOCAL_IPS []string
@@ -96,51 +355,82 @@ func isLocalIP(ip string) bool {
 *********************************************************************/
 
 func (km *KernelManager) LaunchKernel(kernelCmd []string, kw map[string]interface{}) error {
+	km.mu.Lock()
+	km.kernelCmd = kernelCmd
+	km.launchKw = kw
+	km.mu.Unlock()
+	km.ports.unbind()
 	ConnectionInfo, err := km.Provisioner.LaunchKernel(kernelCmd, kw, km.ConnectionFile)
 	if err != nil {
-		return err
+		return diagnoseLaunchError(kernelCmd, err)
 	}
 	log.Debug().Msgf("connectionInfo: %s", ConnectionInfo)
 	return nil
 }
 
-func (km *KernelManager) preLaunch() map[string]interface{} {
+func (km *KernelManager) preLaunch() (map[string]interface{}, error) {
 
 	if km.ConnectionInfo.Transport == "tcp" && !isLocalIP(km.ConnectionInfo.IP) {
 		log.Debug().Msg("Can only launch a kernel on a local interface.")
 	}
 	log.Debug().Msgf("cache ports: %t", km.CachePorts)
-	log.Debug().Msgf("km.Provisioner.PortsCached %t", km.Provisioner.PortsCached)
-
-	if km.CachePorts && !km.Provisioner.PortsCached {
-		km.ConnectionInfo.ShellPort, _ = findAvailablePort()
-		km.ConnectionInfo.IopubPort, _ = findAvailablePort()
-		km.ConnectionInfo.StdinPort, _ = findAvailablePort()
-		km.ConnectionInfo.HbPort, _ = findAvailablePort()
-		km.ConnectionInfo.ControlPort, _ = findAvailablePort()
+
+	if km.ConnectionInfo.Transport == "ipc" {
+		if err := km.ConnectionInfo.prepareIPC(); err != nil {
+			return nil, err
+		}
+	} else if km.CachePorts {
+		ports, err := reservePorts(km.KernelId, 5)
+		if err != nil {
+			return nil, err
+		}
+		km.ports = ports
+		km.ConnectionInfo.ShellPort = ports.Ports[0]
+		km.ConnectionInfo.IopubPort = ports.Ports[1]
+		km.ConnectionInfo.StdinPort = ports.Ports[2]
+		km.ConnectionInfo.HbPort = ports.Ports[3]
+		km.ConnectionInfo.ControlPort = ports.Ports[4]
 		log.Debug().Msgf("connectionInfo : %+v", km.ConnectionInfo)
 	}
 	log.Debug().Msgf("km.ConnectionFile : %+v", km.ConnectionFile)
 
-	km.writeConnectionFile(km.ConnectionFile)
+	if err := km.writeConnectionFile(km.ConnectionFile); err != nil {
+		return nil, err
+	}
 
-	kernelCmd := km.formatKernelCmd()
+	kernelCmd, err := km.formatKernelCmd()
+	if err != nil {
+		return nil, err
+	}
 	log.Debug().Msgf("kernel cmd is %s", kernelCmd)
 
-	env := make(map[string]interface{})
-	env["cmd"] = kernelCmd
-	env["env"] = os.Environ()
-	return env
+	kw := make(map[string]interface{})
+	kw["cmd"] = kernelCmd
+	specVars, sessionVars := km.kernelVars(km.getKernelspec())
+	kw["env"] = kernelEnv(specVars, sessionVars)
+	// containers do not run in the environment of the server
+	kernelVars := newEnvMap(nil)
+	kernelVars.update(specVars)
+	kernelVars.update(sessionVars)
+	kw["kernel_env"] = kernelVars.environ()
+	// remote kernels expand the kernelspec env in their own environment
+	kw["session_env"] = sessionVars.environ()
+	kw["cwd"] = km.cwd
+	return kw, nil
 }
 
-func (km *KernelManager) formatKernelCmd() []string {
+func (km *KernelManager) formatKernelCmd() ([]string, error) {
 
 	cmd := km.getKernelspec().Argv
-	if cmd[0] == "python3" || cmd[0] == "python" {
+	if len(cmd) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrNoSuchKernelspec, km.KernelName)
+	}
+	_, local := km.Provisioner.(*provisioner.LocalProvisioner)
+	if local && (cmd[0] == "python3" || cmd[0] == "python") {
 		pythonVersion, _ := getPython()
 		cmd[0] = pythonVersion
 	}
-	return cmd
+	return cmd, nil
 }
 
 func getPython() (string, error) {
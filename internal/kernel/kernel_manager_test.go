@@ -0,0 +1,68 @@
+package kernel
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zasper-io/zasper/internal/core"
+	"github.com/zasper-io/zasper/internal/kernelspec"
+)
+
+func TestInterruptKernel(t *testing.T) {
+	provisioners := setUpFakeKernels(t, newFakeProvisioner)
+	// a kernel interrupted with an interrupt_request
+	specDir := filepath.Join(core.Zasper.JupyterPath[0], "kernels", "fake-message")
+	require.NoError(t, os.MkdirAll(specDir, 0755))
+	spec := `{"argv": ["fake-kernel", "-f", "{connection_file}"], "display_name": "Fake", "language": "fake", "interrupt_mode": "message"}`
+	require.NoError(t, os.WriteFile(filepath.Join(specDir, "kernel.json"), []byte(spec), 0644))
+	kernelspec.RefreshSpecs()
+
+	tests := []struct {
+		kernelspec string
+		signals    []os.Signal
+	}{
+		{"fake", []os.Signal{syscall.SIGINT}},
+		{"fake-message", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kernelspec, func(t *testing.T) {
+			kernelId, err := StartKernelManager("", tt.kernelspec, nil)
+			require.NoError(t, err)
+			defer KillKernelById(kernelId)
+			require.NoError(t, waitForKernel(t, kernelId))
+
+			assert.NoError(t, interruptKernel(kernelId))
+
+			p, _ := provisioners.Load(kernelId)
+			p.(*fakeProvisioner).mu.Lock()
+			defer p.(*fakeProvisioner).mu.Unlock()
+			assert.Equal(t, tt.signals, p.(*fakeProvisioner).signals)
+		})
+	}
+}
+
+func TestRestartKernel(t *testing.T) {
+	provisioners := setUpFakeKernels(t, newFakeProvisioner)
+
+	kernelId, err := StartKernelManager("", "fake", nil)
+	require.NoError(t, err)
+	defer KillKernelById(kernelId)
+	require.NoError(t, waitForKernel(t, kernelId))
+
+	model, err := restartKernel(kernelId)
+	require.NoError(t, err)
+	assert.Equal(t, ExecutionStateIdle, model.ExecutionState)
+
+	p, _ := provisioners.Load(kernelId)
+	p.(*fakeProvisioner).mu.Lock()
+	defer p.(*fakeProvisioner).mu.Unlock()
+	assert.Equal(t, 2, p.(*fakeProvisioner).launches)
+	assert.False(t, p.(*fakeProvisioner).shutdown)
+
+	_, err = restartKernel("does-not-exist")
+	assert.Error(t, err)
+}
@@ -147,14 +147,20 @@ func (ks *KernelSession) serialize(msg Message) [][]byte {
 		json_packer(msg.Content),
 	}
 	to_send := [][]byte{}
-	log.Debug().Msgf("real message is %s", realMessage)
+	// input replies may carry passwords
+	loggable := msg.Header.MsgType != "input_reply"
+	if loggable {
+		log.Debug().Msgf("real message is %s", realMessage)
+	}
 	signature := ks.sign(realMessage)
 
 	log.Debug().Msgf("signature is %s", signature)
 	to_send = append(to_send, []byte(DELIM))
 	to_send = append(to_send, []byte(signature))
 	to_send = append(to_send, realMessage...)
-	log.Debug().Msgf("after signing message is %s", realMessage)
+	if loggable {
+		log.Debug().Msgf("after signing message is %s", realMessage)
+	}
 	return to_send
 }
 
@@ -167,6 +173,17 @@ func (ks *KernelSession) sign(msg_list [][]byte) string {
 }
 
 func (ks *KernelSession) Deserialize(zmsg zmq4.Msg, chanel string) []byte {
+	kernelResponseMsg := ks.deserializeMessage(zmsg, chanel)
+
+	jsonBytes, err := json.Marshal(kernelResponseMsg)
+	if err != nil {
+		log.Error().Msgf("Error marshaling message: %v", err)
+		return nil
+	}
+	return jsonBytes
+}
+
+func (ks *KernelSession) deserializeMessage(zmsg zmq4.Msg, chanel string) Message {
 
 	msg := zmsg.Bytes()
 	log.Debug().Msgf("Received from IoPub socket: %s\n", msg)
@@ -191,13 +208,11 @@ func (ks *KernelSession) Deserialize(zmsg zmq4.Msg, chanel string) []byte {
 		_, err := hex.Decode(signature, frames[i+1])
 		if err != nil {
 			kernelResponseMsg.Error = fmt.Errorf("invalid signature: while decoding message")
-			jsonBytes, _ := json.Marshal(kernelResponseMsg)
-			return jsonBytes
+			return kernelResponseMsg
 		}
 		if !hmac.Equal(mac.Sum(nil), signature) {
 			kernelResponseMsg.Error = fmt.Errorf("invalid signature: while comparing message")
-			jsonBytes, _ := json.Marshal(kernelResponseMsg)
-			return jsonBytes
+			return kernelResponseMsg
 		}
 	}
 
@@ -222,12 +237,5 @@ func (ks *KernelSession) Deserialize(zmsg zmq4.Msg, chanel string) []byte {
 	}
 
 	kernelResponseMsg.Channel = chanel
-
-	jsonBytes, err := json.Marshal(kernelResponseMsg)
-	if err != nil {
-		log.Error().Msgf("Error marshaling message: %v", err)
-		return nil
-	}
-	return jsonBytes
-
+	return kernelResponseMsg
 }
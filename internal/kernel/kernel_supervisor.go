@@ -1,11 +1,16 @@
 package kernel
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"syscall"
+	"slices"
+	"time"
 
+	"github.com/zasper-io/zasper/internal/content"
+	"github.com/zasper-io/zasper/internal/core"
+	"github.com/zasper-io/zasper/internal/kernel/provisioner"
 	"github.com/zasper-io/zasper/internal/models"
 
 	"github.com/google/uuid"
@@ -13,148 +18,273 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-var ZasperPendingKernels map[string]KernelManager
-var ZasperActiveKernels map[string]KernelManager
+// KernelTransport is how Zasper connects to the channels of local kernels:
+// "tcp" on the loopback interface, or "ipc" on unix sockets in the Jupyter
+// runtime directory, which other users of the host cannot reach.
+var KernelTransport = "tcp"
 
-func SetUpStateKernels() map[string]KernelManager {
-	return make(map[string]KernelManager)
+var ZasperPendingKernels *KernelRegistry
+var ZasperActiveKernels *KernelRegistry
+
+func SetUpStateKernels() *KernelRegistry {
+	return NewKernelRegistry()
 }
 
 func Cleanup() {
-	for _, km := range ZasperActiveKernels {
-		killKernel(km.Provisioner.Pid)
+	for _, km := range ZasperActiveKernels.List() {
+		if err := km.StopKernel(km.KernelId); err != nil {
+			log.Error().Msgf("Error stopping kernel %s: %v", km.KernelId, err)
+		}
 	}
 }
 
-func killKernel(pid int) {
-	// Get the process by PID
-	process, err := os.FindProcess(pid)
-	if err != nil {
-		log.Error().Msgf("Error finding process: %v\n", err)
-		return
-	}
+func NotifyConnect(kernelId string) {
+	updateConnections(kernelId, 1)
+}
 
-	// Attempt to kill the process
-	err = process.Kill()
-	if err != nil {
-		if err == syscall.ESRCH {
-			log.Error().Msgf("No such process.")
-		} else if err == syscall.EPERM {
-			log.Error().Msgf("Permission denied.")
-		} else {
-			log.Error().Msgf("Error killing process: %v\n", err)
-		}
+func NotifyDisconnect(kernelId string) {
+	updateConnections(kernelId, -1)
+}
+
+func updateConnections(kernelId string, delta int) {
+	km, ok := ZasperActiveKernels.Get(kernelId)
+	if !ok {
 		return
 	}
 
-	log.Debug().Msgf("Process %d killed successfully.\n", pid)
-}
+	km.mu.Lock()
+	km.Connections = max(km.Connections+delta, 0)
+	km.LastActivity = time.Now().UTC()
+	km.scheduleInputCancelLocked()
+	km.mu.Unlock()
 
-func NotifyConnect() {
+	ZasperActiveKernels.publishStatus(km)
 }
 
-func NotifyDisconnect(kernelId string) {
+func KillKernelById(kernelId string) error {
+	km, ok := ZasperActiveKernels.Remove(kernelId)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoSuchKernel, kernelId)
+	}
 
-}
+	ZasperPendingKernels.Remove(kernelId)
 
-func KillKernelById(kernelId string) error {
-	km := ZasperActiveKernels[kernelId]
-	NotifyDisconnect(km.KernelId)
-	killKernel(km.Provisioner.Pid)
-	delete(ZasperActiveKernels, kernelId)
-	return nil
+	km.mu.Lock()
+	km.ExecutionState = ExecutionStateDead
+	km.Connections = 0
+	km.mu.Unlock()
+
+	return km.StopKernel(kernelId)
 }
 
 func listKernels() ([]models.KernelModel, error) {
-	kernelIds := listKernelIds()
-
 	kernels := []models.KernelModel{}
-
-	for _, kernelId := range kernelIds {
-		kernel, _ := getKernel(kernelId)
-		kernels = append(kernels, kernel)
+	for _, km := range ZasperActiveKernels.List() {
+		kernels = append(kernels, km.model())
 	}
 	return kernels, nil
 }
 
+var ErrNoSuchKernel = errors.New("no such kernel")
+
 func getKernel(kernelId string) (models.KernelModel, error) {
-	km := ZasperActiveKernels[kernelId]
-	kernel := models.KernelModel{
-		Id:             kernelId,
+	km, ok := ZasperActiveKernels.Get(kernelId)
+	if !ok {
+		return models.KernelModel{}, fmt.Errorf("%w: %s", ErrNoSuchKernel, kernelId)
+	}
+	return km.model(), nil
+}
+
+func (km *KernelManager) model() models.KernelModel {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	return models.KernelModel{
+		Id:             km.KernelId,
 		Name:           km.KernelName,
-		LastActivity:   km.LastActivity,
+		LastActivity:   formatActivity(km.LastActivity),
 		ExecutionState: km.ExecutionState,
+		Reason:         km.Reason,
 		Connections:    km.Connections,
 	}
-	return kernel, nil
 }
 
-func interruptKernel(kernelId string) error {
-	km := ZasperActiveKernels[kernelId]
-
-	pid := km.Provisioner.Pid
-	process, err := os.FindProcess(pid)
-	if err != nil {
-		return fmt.Errorf("Failed to find process %d: %v", pid, err)
-	}
+// GetKernelModel returns the current model of an active kernel.
+func GetKernelModel(kernelId string) (models.KernelModel, error) {
+	return getKernel(kernelId)
+}
 
-	err = process.Signal(syscall.SIGINT)
-	if err != nil {
-		return fmt.Errorf("Failed to send SIGINT to process %d: %v", pid, err)
+func interruptKernel(kernelId string) error {
+	km, ok := ZasperActiveKernels.Get(kernelId)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoSuchKernel, kernelId)
 	}
-
-	return nil
+	return km.Interrupt()
 }
 
-func listKernelIds() []string {
-	keys := make([]string, 0, len(ZasperActiveKernels))
-	for key := range ZasperActiveKernels {
-		keys = append(keys, key)
+func restartKernel(kernelId string) (models.KernelModel, error) {
+	km, ok := ZasperActiveKernels.Get(kernelId)
+	if !ok {
+		return models.KernelModel{}, fmt.Errorf("kernel %s not found", kernelId)
 	}
-	return keys
+	err := km.Restart()
+	return km.model(), err
 }
 
-func StartKernelManager(kernelPath string, kernelName string, env map[string]string) (string, error) {
+// StartKernelManager registers a new kernel in the starting state and
+// launches it in the background. Only errors that prevent the launch from
+// being attempted at all are returned.
+func StartKernelManager(cwd string, kernelName string, env map[string]string) (string, error) {
 	kernelId := uuid.New().String()
 
 	km, kernel_name, kernel_id := createKernelManager(kernelName, kernelId)
-	log.Debug().Msgf("%v | %v | %v ", km, kernel_name, kernel_id)
+	log.Debug().Msgf("%v | %v ", kernel_name, kernel_id)
 
-	err := km.StartKernel(kernelName)
+	spec := km.getKernelspec()
+	if len(spec.Argv) == 0 {
+		return "", fmt.Errorf("%w: %q", ErrNoSuchKernelspec, kernelName)
+	}
+	km.interruptMode = spec.InterruptMode
+	km.sessionEnv = env
+	km.cwd = cwd
+
+	km.ExecutionState = ExecutionStateStarting
+	km.LastActivity = time.Now().UTC()
+
+	ZasperPendingKernels.Add(km)
+	ZasperActiveKernels.Add(km)
+
+	go km.startInBackground()
+	return kernelId, nil
+}
+
+var ErrInvalidConnectionInfo = errors.New("invalid connection info")
 
-	if err != nil {
-		return "", err
+// AttachKernel registers a kernel started outside of Zasper, e.g. by jupyter
+// console or a batch job, from its connection info. Zasper talks to it like
+// to its own kernels but never restarts or stops it: shutting it down only
+// detaches from it.
+func AttachKernel(info ConnectionFileData, connectionFile string) (string, error) {
+	if info.Transport == "" {
+		info.Transport = "tcp"
+	}
+	if info.IP == "" && info.Transport == "tcp" {
+		info.IP = "127.0.0.1"
+	}
+	if info.SignatureScheme == "" {
+		info.SignatureScheme = "hmac-sha256"
+	}
+	switch {
+	case info.Transport != "tcp" && info.Transport != "ipc":
+		return "", fmt.Errorf("%w: unknown transport %q", ErrInvalidConnectionInfo, info.Transport)
+	case info.IP == "":
+		return "", fmt.Errorf("%w: no ip", ErrInvalidConnectionInfo)
+	case info.SignatureScheme != "hmac-sha256":
+		return "", fmt.Errorf("%w: unsupported signature scheme %q", ErrInvalidConnectionInfo, info.SignatureScheme)
+	case slices.ContainsFunc([]int{info.ShellPort, info.IopubPort, info.StdinPort, info.HbPort, info.ControlPort}, func(port int) bool { return port <= 0 }):
+		return "", fmt.Errorf("%w: missing ports", ErrInvalidConnectionInfo)
 	}
 
-	ZasperActiveKernels[kernelId] = km
+	kernelId := uuid.New().String()
+	km := &KernelManager{
+		ConnectionFile: connectionFile,
+		KernelName:     info.KernelName,
+		KernelId:       kernelId,
+		Kernelspec:     info.KernelName,
+		ready:          make(chan struct{}),
+		Logs:           NewLogBuffer(kernelId, KernelLogLines),
+		// its process may be on another host
+		interruptMode: "message",
+	}
+	km.ConnectionInfo = Connection{
+		Transport:   info.Transport,
+		IP:          info.IP,
+		ShellPort:   info.ShellPort,
+		IopubPort:   info.IopubPort,
+		StdinPort:   info.StdinPort,
+		HbPort:      info.HbPort,
+		ControlPort: info.ControlPort,
+	}
+	km.Session.SignatureScheme = info.SignatureScheme
+	km.Session.setKey(info.Key)
+	km.Provisioner = provisioner.NewExternalProvisioner(kernelId)
 
+	km.ExecutionState = ExecutionStateStarting
+	km.LastActivity = time.Now().UTC()
+	log.Info().Msgf("attaching kernel %s to %s://%s", kernelId, info.Transport, info.IP)
+
+	ZasperPendingKernels.Add(km)
+	ZasperActiveKernels.Add(km)
+
+	go km.startInBackground()
 	return kernelId, nil
 }
 
 func StopKernelManager(kernelId string) {
-	km := ZasperActiveKernels[kernelId]
-	km.StopKernel(kernelId)
-	// todo
+	ZasperPendingKernels.Remove(kernelId)
+	km, ok := ZasperActiveKernels.Remove(kernelId)
+	if !ok {
+		log.Warn().Msgf("kernel %s not found", kernelId)
+		return
+	}
+	if err := km.StopKernel(kernelId); err != nil {
+		log.Error().Msgf("Error stopping kernel %s: %v", kernelId, err)
+	}
 }
 
-func CwdForPath(path string) string {
-	return path
+// GetKernelManager returns the manager of an active kernel.
+func GetKernelManager(kernelId string) (*KernelManager, bool) {
+	return ZasperActiveKernels.Get(kernelId)
 }
 
-func createKernelManager(kernelName string, kernelId string) (KernelManager, string, string) {
-	connectionDir := os.TempDir()
-	connectionFile := filepath.Join(connectionDir, "kernel-"+kernelId[:6]+".json")
-	km := KernelManager{
-		ConnectionFile: filepath.Join(connectionFile),
+// CwdForPath returns the directory a kernel for the notebook or directory at
+// path, relative to the project root, should run in: the closest existing
+// directory containing it.
+func CwdForPath(path string) (string, error) {
+	osPath := content.GetSafePath(path)
+	if osPath == "" {
+		return "", fmt.Errorf("%w: %q", ErrPathOutsideProject, path)
+	}
+	homeDir := filepath.Clean(core.Zasper.HomeDir)
+	for osPath != homeDir && filepath.Dir(osPath) != osPath {
+		if info, err := os.Stat(osPath); err == nil && info.IsDir() {
+			break
+		}
+		osPath = filepath.Dir(osPath)
+	}
+	return osPath, nil
+}
+
+func createKernelManager(kernelName string, kernelId string) (*KernelManager, string, string) {
+	connectionDir := core.Zasper.JupyterRuntimeDir
+	if connectionDir == "" {
+		connectionDir = os.TempDir()
+	}
+	km := &KernelManager{
+		ConnectionFile: filepath.Join(connectionDir, "kernel-"+kernelId+".json"),
 		KernelName:     kernelName,
 		KernelId:       kernelId,
+		OwnsKernel:     true,
 		CachePorts:     true,
 		Kernelspec:     kernelName,
 		// todo find from kernelspec dict
+		ready: make(chan struct{}),
+		Logs:  NewLogBuffer(kernelId, KernelLogLines),
 	}
 	km.ConnectionInfo.Transport = "tcp"
 	km.ConnectionInfo.IP = "127.0.0.1"
 	km.Session = getSession()
+	km.Provisioner = newProvisioner(km)
+	if KernelTransport == "ipc" {
+		switch km.Provisioner.(type) {
+		case *provisioner.SSHProvisioner, *provisioner.ContainerProvisioner:
+			// their kernels are reached through TCP ports
+			log.Info().Msgf("kernel %s uses the tcp transport of its provisioner", kernelId)
+		default:
+			km.ConnectionInfo.Transport = "ipc"
+			// the sockets are in a directory of the kernel, see prepareIPC
+			km.ConnectionInfo.IP = filepath.Join(core.Zasper.JupyterRuntimeDir, "kernel-"+kernelId+"-ipc", "kernel")
+		}
+	}
 	log.Debug().Msgf("session is %v", km.Session)
 	return km, kernelName, kernelId
 }
@@ -0,0 +1,56 @@
+package kernel
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zasper-io/zasper/internal/core"
+)
+
+func TestCwdForPath(t *testing.T) {
+	project := t.TempDir()
+	core.Zasper.HomeDir = project
+	require.NoError(t, os.MkdirAll(filepath.Join(project, "analysis", "data"), 0755))
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"", project},
+		{"/", project},
+		{"notebook.ipynb", project},
+		{"analysis/notebook.ipynb", filepath.Join(project, "analysis")},
+		{"/analysis/data", filepath.Join(project, "analysis", "data")},
+		{"analysis/missing/notebook.ipynb", filepath.Join(project, "analysis")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			cwd, err := CwdForPath(tt.path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, cwd)
+		})
+	}
+
+	for _, path := range []string{"../elsewhere", "analysis/../../" + filepath.Base(project) + "-other"} {
+		_, err := CwdForPath(path)
+		assert.ErrorIs(t, err, ErrPathOutsideProject, path)
+	}
+}
+
+func TestKernelLaunchedInCwd(t *testing.T) {
+	provisioners := setUpFakeKernels(t, newFakeProvisioner)
+	cwd := t.TempDir()
+
+	kernelId, err := StartKernelManager(cwd, "fake", nil)
+	require.NoError(t, err)
+	defer KillKernelById(kernelId)
+	require.NoError(t, waitForKernel(t, kernelId))
+
+	p, _ := provisioners.Load(kernelId)
+	p.(*fakeProvisioner).mu.Lock()
+	defer p.(*fakeProvisioner).mu.Unlock()
+	assert.Equal(t, cwd, p.(*fakeProvisioner).kw["cwd"])
+}
@@ -2,13 +2,15 @@ package launcher
 
 import (
 	"io"
-	"os"
 	"os/exec"
+	"syscall"
 
 	"github.com/rs/zerolog/log"
 )
 
-func LaunchKernel(kernelCmd []string, kw map[string]interface{}, connFile string) (*os.Process, error) {
+// LaunchKernel starts the kernel command, sending its output to stdout and
+// stderr. The kernel gets no standard input.
+func LaunchKernel(kernelCmd []string, kw map[string]interface{}, connFile string, stdout, stderr io.Writer, attr *syscall.SysProcAttr) (*exec.Cmd, error) {
 	// Log which python will be used
 	// pythonCmd := kernelCmd[0]
 	// pythonPath, err := exec.LookPath(pythonCmd)
@@ -41,65 +43,24 @@ func LaunchKernel(kernelCmd []string, kw map[string]interface{}, connFile string
 
 	cmd := exec.Command(kernelCmd[0], kernelCmd[1:]...)
 
-	// Create pipes for standard input, output, and error
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		log.Error().Msgf("Error creating stdin pipe: %v", err)
-		return nil, err
-	}
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Error().Msgf("Error creating stdout pipe: %v", err)
-		return nil, err
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = attr
+	if env, ok := kw["env"].([]string); ok {
+		cmd.Env = env
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		log.Error().Msgf("Error creating stderr pipe: %v", err)
-		return nil, err
+	if cwd, ok := kw["cwd"].(string); ok {
+		cmd.Dir = cwd
 	}
+
 	// Start the command
 	if err := cmd.Start(); err != nil {
 		log.Error().Msgf("Error starting command: %v", err)
 		return nil, err
 	}
 
-	// Send input to the process
-	go func() {
-		defer stdin.Close()
-		if _, err := stdin.Write([]byte("input data\n")); err != nil {
-			log.Fatal().Msgf("Error writing to stdin: %v", err)
-		}
-	}()
-
-	// Capture stdout and stderr
-	go func() {
-		if _, err := io.Copy(os.Stdout, stdout); err != nil {
-			log.Fatal().Msgf("Error copying stdout: %v", err)
-		}
-	}()
-
-	go func() {
-		if _, err := io.Copy(os.Stderr, stderr); err != nil {
-			log.Fatal().Msgf("Error copying stderr: %v", err)
-		}
-	}()
-
 	log.Debug().Msg("Process started successfully")
 
-	return cmd.Process, nil
-
-}
-
-func ShutdownKernel(pid int) {
-	// Find the process
-	process, err := os.FindProcess(pid)
-	if err != nil {
-		log.Fatal().Msgf("Error finding process: %v", err)
-	}
+	return cmd, nil
 
-	// Kill the process
-	if err := process.Kill(); err != nil {
-		log.Fatal().Msgf("Error killing process: %v", err)
-	}
-	log.Info().Msgf("Process %d killed successfully.", pid)
 }
@@ -0,0 +1,13 @@
+package kernel
+
+import (
+	"os"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	os.Exit(m.Run())
+}
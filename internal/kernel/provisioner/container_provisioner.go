@@ -0,0 +1,554 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zasper-io/zasper/internal/core"
+	"github.com/zasper-io/zasper/internal/kernelspec"
+
+	"github.com/rs/zerolog/log"
+)
+
+// containerConnectionFile is where the connection file is mounted in the
+// container.
+const containerConnectionFile = "/run/zasper/kernel.json"
+
+// ContainerConfig is the "config" of a kernelspec whose kernel_provisioner is
+// "docker" or "podman", e.g.
+//
+//	"metadata": {"kernel_provisioner": {"provisioner_name": "docker",
+//	    "config": {"image": "quay.io/jupyter/scipy-notebook:2024-05-27"}}}
+type ContainerConfig struct {
+	Image string `json:"image"`
+	// Socket is the unix socket of the engine API. It defaults to the
+	// socket of DOCKER_HOST or of the rootless podman service.
+	Socket string `json:"socket"`
+	// User runs the kernel, by default the owner of the project for docker
+	// and the user of the image for podman.
+	User string `json:"user"`
+	// Network is the network of the container, the default bridge network
+	// of the engine if empty.
+	Network string `json:"network"`
+}
+
+func containerConfigFromKernelspec(engine string, spec kernelspec.KernelSpecJsonData) (ContainerConfig, error) {
+	var metadata struct {
+		KernelProvisioner struct {
+			Config ContainerConfig `json:"config"`
+		} `json:"kernel_provisioner"`
+	}
+	if err := decodeMetadata(spec, &metadata); err != nil {
+		return ContainerConfig{}, fmt.Errorf("invalid kernel_provisioner in kernelspec %s: %w", spec.Name, err)
+	}
+	config := metadata.KernelProvisioner.Config
+	if config.Image == "" {
+		return config, fmt.Errorf("kernelspec %s: the %s provisioner needs an image", spec.Name, engine)
+	}
+	if config.Socket == "" {
+		config.Socket = defaultEngineSocket(engine)
+	}
+	if config.User == "" && engine == "docker" && os.Getuid() >= 0 {
+		// files written to the project belong to its user
+		config.User = fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	}
+	return config, nil
+}
+
+func defaultEngineSocket(engine string) string {
+	if engine == "podman" {
+		if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+			socket := filepath.Join(runtimeDir, "podman", "podman.sock")
+			if _, err := os.Stat(socket); err == nil {
+				return socket
+			}
+		}
+		return "/run/podman/podman.sock"
+	}
+	if host := os.Getenv("DOCKER_HOST"); strings.HasPrefix(host, "unix://") {
+		return strings.TrimPrefix(host, "unix://")
+	}
+	return "/var/run/docker.sock"
+}
+
+// ContainerProvisioner runs the kernel in a Docker or Podman container through
+// the API of the engine. The project directory is mounted at the same path,
+// and the ports of the kernel are published on the loopback interface at the
+// ports of the connection info.
+type ContainerProvisioner struct {
+	Kernelspec kernelspec.KernelSpecJsonData
+	KernelId   string
+	Engine     string
+	Config     ContainerConfig
+	Limits     ResourceLimits
+	Stdout     io.Writer
+	Stderr     io.Writer
+
+	configErr error
+	engine    *engineClient
+
+	mu          sync.Mutex
+	containerId string
+	connFile    string
+	restarting  bool
+	exited      chan struct{}
+	exitErr     error
+}
+
+func NewContainerProvisioner(kernelId string, engine string, spec kernelspec.KernelSpecJsonData, limits ResourceLimits, stdout, stderr io.Writer) *ContainerProvisioner {
+	config, err := containerConfigFromKernelspec(engine, spec)
+	return &ContainerProvisioner{
+		Kernelspec: spec,
+		KernelId:   kernelId,
+		Engine:     engine,
+		Config:     config,
+		Limits:     limits,
+		Stdout:     stdout,
+		Stderr:     stderr,
+		configErr:  err,
+		engine:     newEngineClient(config.Socket),
+	}
+}
+
+func (p *ContainerProvisioner) LaunchKernel(kernelCmd []string, kw map[string]interface{}, connFile string) (KernelConnectionInfo, error) {
+	if p.configErr != nil {
+		return nil, p.configErr
+	}
+	if p.Stdout == nil {
+		p.Stdout = os.Stdout
+	}
+	if p.Stderr == nil {
+		p.Stderr = os.Stderr
+	}
+	localInfo, err := readConnectionFile(connFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// the kernel listens on all the interfaces of the container
+	containerInfo := KernelConnectionInfo{}
+	for k, v := range localInfo {
+		containerInfo[k] = v
+	}
+	containerInfo["ip"] = "0.0.0.0"
+	containerInfo["transport"] = "tcp"
+	containerConnFile := strings.TrimSuffix(connFile, ".json") + "-container.json"
+	data, err := json.Marshal(containerInfo)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(containerConnFile, data, 0600); err != nil {
+		return nil, err
+	}
+
+	cwd, _ := kw["cwd"].(string)
+	env, _ := kw["kernel_env"].([]string)
+	body := p.createRequest(kernelCmd, env, localInfo, containerConnFile, cwd)
+	containerId, err := p.createContainer(body)
+	if err != nil {
+		os.Remove(containerConnFile)
+		return nil, err
+	}
+	exited := make(chan struct{})
+	p.mu.Lock()
+	p.containerId = containerId
+	p.connFile = containerConnFile
+	p.exited = exited
+	p.mu.Unlock()
+
+	if err := p.engine.do(http.MethodPost, "/containers/"+containerId+"/start", nil, nil); err != nil {
+		p.removeContainer()
+		close(exited)
+		return nil, fmt.Errorf("cannot start container: %w", err)
+	}
+	log.Info().Msgf("kernel %s launched in %s container %.12s (%s)", p.KernelId, p.Engine, containerId, p.Config.Image)
+
+	go p.followLogs(time.Time{})
+	go p.wait(exited)
+	return localInfo, nil
+}
+
+// createRequest is the body of the container create request. env holds the
+// variables set by the kernel manager, without the environment of the server
+// which is not the one of the image.
+func (p *ContainerProvisioner) createRequest(kernelCmd []string, env []string, info KernelConnectionInfo, containerConnFile string, cwd string) map[string]interface{} {
+	cmd := make([]string, len(kernelCmd))
+	for i, arg := range kernelCmd {
+		if arg == "{connection_file}" {
+			arg = containerConnectionFile
+		}
+		cmd[i] = arg
+	}
+	if env == nil {
+		env = []string{}
+	}
+
+	exposedPorts := map[string]interface{}{}
+	portBindings := map[string]interface{}{}
+	for _, port := range channelPorts {
+		number, _ := info[port].(float64)
+		containerPort := strconv.Itoa(int(number)) + "/tcp"
+		exposedPorts[containerPort] = map[string]interface{}{}
+		portBindings[containerPort] = []map[string]string{{"HostIp": "127.0.0.1", "HostPort": strconv.Itoa(int(number))}}
+	}
+
+	hostConfig := map[string]interface{}{
+		"Binds": []string{
+			core.Zasper.HomeDir + ":" + core.Zasper.HomeDir,
+			containerConnFile + ":" + containerConnectionFile,
+		},
+		"PortBindings": portBindings,
+		// forward signals to the kernel and reap its children
+		"Init": true,
+	}
+	if p.Config.Network != "" {
+		hostConfig["NetworkMode"] = p.Config.Network
+	}
+	if p.Limits.MemoryMax > 0 {
+		hostConfig["Memory"] = int64(p.Limits.MemoryMax)
+		hostConfig["MemorySwap"] = int64(p.Limits.MemoryMax)
+	}
+	if p.Limits.CPUQuota > 0 {
+		hostConfig["NanoCpus"] = int64(p.Limits.CPUQuota * 1e9)
+	}
+	if p.Limits.PidsMax > 0 {
+		hostConfig["PidsLimit"] = p.Limits.PidsMax
+	}
+
+	body := map[string]interface{}{
+		"Image":        p.Config.Image,
+		"Cmd":          cmd,
+		"Env":          env,
+		"ExposedPorts": exposedPorts,
+		"HostConfig":   hostConfig,
+		"Labels":       map[string]string{"io.zasper.kernel-id": p.KernelId},
+	}
+	if cwd != "" {
+		body["WorkingDir"] = cwd
+	}
+	if p.Config.User != "" {
+		body["User"] = p.Config.User
+	}
+	return body
+}
+
+// createContainer creates the container, pulling its image when the engine
+// does not have it yet.
+func (p *ContainerProvisioner) createContainer(body map[string]interface{}) (string, error) {
+	path := "/containers/create?name=" + url.QueryEscape("zasper-kernel-"+p.KernelId)
+	var created struct {
+		Id string `json:"Id"`
+	}
+	err := p.engine.do(http.MethodPost, path, body, &created)
+	var engineErr *EngineError
+	if errors.As(err, &engineErr) && engineErr.StatusCode == http.StatusNotFound {
+		log.Info().Msgf("pulling image %s for kernel %s", p.Config.Image, p.KernelId)
+		if err := p.engine.pull(p.Config.Image); err != nil {
+			return "", fmt.Errorf("cannot pull %s: %w", p.Config.Image, err)
+		}
+		err = p.engine.do(http.MethodPost, path, body, &created)
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot create container: %w", err)
+	}
+	return created.Id, nil
+}
+
+// wait waits for the container to stop, except when it is being restarted,
+// and then removes it.
+func (p *ContainerProvisioner) wait(exited chan struct{}) {
+	for {
+		p.mu.Lock()
+		containerId := p.containerId
+		p.mu.Unlock()
+
+		var result struct {
+			StatusCode int `json:"StatusCode"`
+			Error      *struct {
+				Message string `json:"Message"`
+			} `json:"Error"`
+		}
+		err := p.engine.stream(http.MethodPost, "/containers/"+containerId+"/wait", func(body io.Reader) error {
+			return json.NewDecoder(body).Decode(&result)
+		})
+		if err == nil && result.Error != nil && result.Error.Message != "" {
+			err = errors.New(result.Error.Message)
+		}
+		if err == nil && result.StatusCode != 0 {
+			err = fmt.Errorf("container exited with status %d", result.StatusCode)
+		}
+
+		p.mu.Lock()
+		restarting := p.restarting
+		p.mu.Unlock()
+		if restarting || p.running(containerId) {
+			// the engine stops the container before starting it again
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		p.mu.Lock()
+		p.exitErr = err
+		p.mu.Unlock()
+		log.Info().Msgf("kernel %s container exited: %v", p.KernelId, err)
+		p.removeContainer()
+		close(exited)
+		return
+	}
+}
+
+// running reports whether the container has been started again, by a
+// restart that completed before its stop was noticed.
+func (p *ContainerProvisioner) running(containerId string) bool {
+	var inspect struct {
+		State struct {
+			Running    bool `json:"Running"`
+			Restarting bool `json:"Restarting"`
+		} `json:"State"`
+	}
+	if err := p.engine.do(http.MethodGet, "/containers/"+containerId+"/json", nil, &inspect); err != nil {
+		return false
+	}
+	return inspect.State.Running || inspect.State.Restarting
+}
+
+// followLogs copies the output of the kernel to Stdout and Stderr until the
+// container stops.
+func (p *ContainerProvisioner) followLogs(since time.Time) {
+	p.mu.Lock()
+	containerId := p.containerId
+	p.mu.Unlock()
+	path := "/containers/" + containerId + "/logs?follow=1&stdout=1&stderr=1"
+	if !since.IsZero() {
+		path += "&since=" + strconv.FormatInt(since.Unix(), 10)
+	}
+	err := p.engine.stream(http.MethodGet, path, func(body io.Reader) error {
+		return demuxLogs(body, p.Stdout, p.Stderr)
+	})
+	if err != nil {
+		log.Debug().Msgf("stopped following logs of kernel %s: %v", p.KernelId, err)
+	}
+}
+
+// demuxLogs splits the multiplexed log stream of a container without a tty
+// into stdout and stderr. Each frame starts with the stream type and the
+// big endian length of the payload.
+func demuxLogs(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+		if _, err := io.CopyN(w, r, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *ContainerProvisioner) Exited() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.exited == nil {
+		p.exited = make(chan struct{})
+	}
+	return p.exited
+}
+
+// ExitError returns the reason the container stopped.
+func (p *ContainerProvisioner) ExitError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.exitErr
+}
+
+func (p *ContainerProvisioner) container() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.containerId
+}
+
+// SignalKernel sends sig to the kernel through the init process of the
+// container.
+func (p *ContainerProvisioner) SignalKernel(sig os.Signal) error {
+	containerId := p.container()
+	if containerId == "" {
+		return fmt.Errorf("kernel %s has no container", p.KernelId)
+	}
+	name, err := signalName(sig)
+	if err != nil {
+		return err
+	}
+	if err := p.engine.do(http.MethodPost, "/containers/"+containerId+"/kill?signal=SIG"+name, nil, nil); err != nil {
+		return fmt.Errorf("Failed to send %v to container %.12s: %v", sig, containerId, err)
+	}
+	return nil
+}
+
+// RestartKernel restarts the container, which keeps its ports and mounts.
+func (p *ContainerProvisioner) RestartKernel() error {
+	containerId := p.container()
+	if containerId == "" {
+		return fmt.Errorf("kernel %s has no container", p.KernelId)
+	}
+	p.mu.Lock()
+	p.restarting = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.restarting = false
+		p.mu.Unlock()
+	}()
+
+	since := time.Now()
+	if err := p.engine.do(http.MethodPost, "/containers/"+containerId+"/restart?t=2", nil, nil); err != nil {
+		return fmt.Errorf("cannot restart container %.12s: %w", containerId, err)
+	}
+	log.Info().Msgf("restarted container %.12s of kernel %s", containerId, p.KernelId)
+	go p.followLogs(since)
+	return nil
+}
+
+// ShutdownKernel kills and removes the container.
+func (p *ContainerProvisioner) ShutdownKernel() error {
+	if p.container() == "" {
+		return nil
+	}
+	log.Info().Msgf("Shutting down kernel %s container", p.KernelId)
+	return p.removeContainer()
+}
+
+func (p *ContainerProvisioner) removeContainer() error {
+	p.mu.Lock()
+	containerId, connFile := p.containerId, p.connFile
+	p.mu.Unlock()
+	if connFile != "" {
+		os.Remove(connFile)
+	}
+	err := p.engine.do(http.MethodDelete, "/containers/"+containerId+"?force=1", nil, nil)
+	var engineErr *EngineError
+	if errors.As(err, &engineErr) && (engineErr.StatusCode == http.StatusNotFound || engineErr.StatusCode == http.StatusConflict) {
+		// already removed, or being removed
+		return nil
+	}
+	return err
+}
+
+// EngineError is an error response of the Docker or Podman API.
+type EngineError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *EngineError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// engineClient talks to the Docker compatible API of the engine on its unix
+// socket.
+type engineClient struct {
+	http *http.Client
+}
+
+func newEngineClient(socket string) *engineClient {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &engineClient{http: &http.Client{Transport: transport}}
+}
+
+// stream sends a request and hands the body of a successful response to read.
+func (c *engineClient) stream(method string, path string, read func(io.Reader) error) error {
+	return c.request(method, path, nil, read)
+}
+
+func (c *engineClient) do(method string, path string, body interface{}, out interface{}) error {
+	return c.request(method, path, body, func(r io.Reader) error {
+		if out == nil {
+			return nil
+		}
+		return json.NewDecoder(r).Decode(out)
+	})
+}
+
+func (c *engineClient) request(method string, path string, body interface{}, read func(io.Reader) error) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, "http://engine"+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		var engineErr struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &engineErr) != nil {
+			engineErr.Message = strings.TrimSpace(string(data))
+		}
+		return &EngineError{StatusCode: resp.StatusCode, Message: engineErr.Message}
+	}
+	return read(resp.Body)
+}
+
+// pull pulls an image, reading the progress stream to the end for errors.
+func (c *engineClient) pull(image string) error {
+	path := "/images/create?fromImage=" + url.QueryEscape(image)
+	if !strings.Contains(image, "@") && !strings.Contains(image[strings.LastIndex(image, "/")+1:], ":") {
+		// without a tag, every tag of the image would be pulled
+		path += "&tag=latest"
+	}
+	return c.stream(http.MethodPost, path, func(body io.Reader) error {
+		decoder := json.NewDecoder(body)
+		for {
+			var progress struct {
+				Error string `json:"error"`
+			}
+			if err := decoder.Decode(&progress); err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+			if progress.Error != "" {
+				return errors.New(progress.Error)
+			}
+		}
+	})
+}
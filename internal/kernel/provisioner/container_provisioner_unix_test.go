@@ -0,0 +1,248 @@
+//go:build !windows
+
+package provisioner
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zasper-io/zasper/internal/core"
+	"github.com/zasper-io/zasper/internal/kernelspec"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEngine implements the parts of the Docker API used by the container
+// provisioner. Its only image has to be pulled before containers are created.
+type fakeEngine struct {
+	mu       sync.Mutex
+	pulled   []string
+	created  map[string]interface{}
+	signals  []string
+	restarts int
+	removed  bool
+	running  bool
+	stopped  chan struct{}
+}
+
+func startFakeEngine(t *testing.T) (*fakeEngine, string) {
+	engine := &fakeEngine{}
+	router := mux.NewRouter()
+	router.HandleFunc("/images/create", engine.pull).Methods(http.MethodPost)
+	router.HandleFunc("/containers/create", engine.create).Methods(http.MethodPost)
+	router.HandleFunc("/containers/{id}/start", engine.start).Methods(http.MethodPost)
+	router.HandleFunc("/containers/{id}/logs", engine.logs).Methods(http.MethodGet)
+	router.HandleFunc("/containers/{id}/wait", engine.wait).Methods(http.MethodPost)
+	router.HandleFunc("/containers/{id}/json", engine.inspect).Methods(http.MethodGet)
+	router.HandleFunc("/containers/{id}/kill", engine.kill).Methods(http.MethodPost)
+	router.HandleFunc("/containers/{id}/restart", engine.restart).Methods(http.MethodPost)
+	router.HandleFunc("/containers/{id}", engine.remove).Methods(http.MethodDelete)
+
+	socket := filepath.Join(t.TempDir(), "engine.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := &http.Server{Handler: router}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return engine, socket
+}
+
+func (e *fakeEngine) pull(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pulled = append(e.pulled, r.URL.RawQuery)
+	io.WriteString(w, `{"status": "Pulling from jupyter/minimal"}`+"\n"+`{"status": "Downloaded newer image"}`+"\n")
+}
+
+func (e *fakeEngine) create(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.pulled) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"message": "No such image: jupyter/minimal:latest"}`)
+		return
+	}
+	json.NewDecoder(r.Body).Decode(&e.created)
+	e.created["name"] = r.URL.Query().Get("name")
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, `{"Id": "c0ffee"}`)
+}
+
+func (e *fakeEngine) start(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.running = true
+	e.stopped = make(chan struct{})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *fakeEngine) logs(w http.ResponseWriter, r *http.Request) {
+	frame := func(stream byte, payload string) {
+		header := make([]byte, 8)
+		header[0] = stream
+		binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+		w.Write(append(header, payload...))
+	}
+	frame(1, "kernel started\n")
+	frame(2, "a warning\n")
+}
+
+func (e *fakeEngine) wait(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	stopped := e.stopped
+	e.mu.Unlock()
+	select {
+	case <-stopped:
+	case <-r.Context().Done():
+		return
+	}
+	io.WriteString(w, `{"StatusCode": 137}`)
+}
+
+func (e *fakeEngine) inspect(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{"State": map[string]bool{"Running": e.running}})
+}
+
+func (e *fakeEngine) kill(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.signals = append(e.signals, r.URL.Query().Get("signal"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *fakeEngine) restart(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.restarts++
+	close(e.stopped)
+	e.stopped = make(chan struct{})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *fakeEngine) remove(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.removed {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"message": "No such container: c0ffee"}`)
+		return
+	}
+	e.removed = true
+	if e.running {
+		e.running = false
+		close(e.stopped)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestContainerProvisioner(t *testing.T) {
+	engine, socket := startFakeEngine(t)
+	core.Zasper.HomeDir = t.TempDir()
+	spec := kernelspec.KernelSpecJsonData{
+		Name: "container",
+		Argv: []string{"python", "-m", "ipykernel_launcher", "-f", "{connection_file}"},
+		Env:  map[string]string{"GREETING": "hello"},
+		Metadata: map[string]interface{}{
+			"kernel_provisioner": map[string]interface{}{
+				"provisioner_name": "docker",
+				"config":           map[string]interface{}{"image": "jupyter/minimal", "socket": socket, "user": "1000:100"},
+			},
+		},
+	}
+	assert.Equal(t, "docker", ProvisionerName(spec))
+	connFile, localInfo := writeLocalConnectionFile(t)
+	limits := ResourceLimits{MemoryMax: 1 << 30, CPUQuota: 1.5, PidsMax: 256}
+
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	p := NewContainerProvisioner("container-kernel", "docker", spec, limits, stdout, stderr)
+	kw := map[string]interface{}{
+		"cwd":        core.Zasper.HomeDir,
+		"kernel_env": []string{"GREETING=hello", "JPY_SESSION_NAME=/data/notebook.ipynb"},
+	}
+	_, err := p.LaunchKernel(append([]string{}, spec.Argv...), kw, connFile)
+	require.NoError(t, err)
+
+	// the missing image was pulled at its latest tag
+	engine.mu.Lock()
+	assert.Equal(t, []string{"fromImage=jupyter%2Fminimal&tag=latest"}, engine.pulled)
+	created := engine.created
+	engine.mu.Unlock()
+	assert.Equal(t, "zasper-kernel-container-kernel", created["name"])
+	assert.Equal(t, []interface{}{"python", "-m", "ipykernel_launcher", "-f", containerConnectionFile}, created["Cmd"])
+	assert.Equal(t, []interface{}{"GREETING=hello", "JPY_SESSION_NAME=/data/notebook.ipynb"}, created["Env"])
+	assert.Equal(t, core.Zasper.HomeDir, created["WorkingDir"])
+	assert.Equal(t, "1000:100", created["User"])
+
+	hostConfig := created["HostConfig"].(map[string]interface{})
+	containerConnFile := filepath.Join(filepath.Dir(connFile), "kernel-container.json")
+	assert.Equal(t, []interface{}{
+		core.Zasper.HomeDir + ":" + core.Zasper.HomeDir,
+		containerConnFile + ":" + containerConnectionFile,
+	}, hostConfig["Binds"])
+	assert.Equal(t, float64(1<<30), hostConfig["Memory"])
+	assert.Equal(t, float64(1.5e9), hostConfig["NanoCpus"])
+	assert.Equal(t, float64(256), hostConfig["PidsLimit"])
+	portBindings := hostConfig["PortBindings"].(map[string]interface{})
+	assert.Len(t, portBindings, len(channelPorts))
+	shellPort := strconv.Itoa(localInfo["shell_port"].(int))
+	assert.Equal(t, []interface{}{map[string]interface{}{"HostIp": "127.0.0.1", "HostPort": shellPort}}, portBindings[shellPort+"/tcp"])
+
+	// the kernel in the container listens on all its interfaces
+	containerInfo, err := readConnectionFile(containerConnFile)
+	require.NoError(t, err)
+	assert.Equal(t, "0.0.0.0", containerInfo["ip"])
+	assert.Equal(t, localInfo["key"], containerInfo["key"])
+	info, err := os.Stat(containerConnFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	assert.Eventually(t, func() bool {
+		return stdout.String() == "kernel started\n" && stderr.String() == "a warning\n"
+	}, 5*time.Second, 20*time.Millisecond)
+
+	require.NoError(t, p.SignalKernel(os.Interrupt))
+	require.NoError(t, p.RestartKernel())
+	select {
+	case <-p.Exited():
+		t.Fatal("a restart is not an exit")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	require.NoError(t, p.ShutdownKernel())
+	select {
+	case <-p.Exited():
+	case <-time.After(5 * time.Second):
+		t.Fatal("container did not exit")
+	}
+	assert.EqualError(t, p.ExitError(), "container exited with status 137")
+	assert.NoFileExists(t, containerConnFile)
+
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	assert.Equal(t, []string{"SIGINT"}, engine.signals)
+	assert.Equal(t, 1, engine.restarts)
+	assert.True(t, engine.removed)
+}
+
+func TestContainerProvisionerNeedsImage(t *testing.T) {
+	spec := kernelspec.KernelSpecJsonData{
+		Name:     "container",
+		Metadata: map[string]interface{}{"kernel_provisioner": map[string]interface{}{"provisioner_name": "podman"}},
+	}
+	p := NewContainerProvisioner("no-image", "podman", spec, ResourceLimits{}, nil, nil)
+	_, err := p.LaunchKernel(nil, nil, "")
+	assert.ErrorContains(t, err, "needs an image")
+}
@@ -0,0 +1,42 @@
+package provisioner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrNotOwned is returned for the operations on the process of a kernel that
+// was started outside of Zasper.
+var ErrNotOwned = errors.New("kernel was not started by Zasper")
+
+// ExternalProvisioner stands for a kernel started outside of Zasper, e.g. by
+// jupyter console or a batch job, which Zasper attached to through its
+// connection file. Zasper has no hold on its process and never stops it.
+type ExternalProvisioner struct {
+	KernelId string
+
+	exited chan struct{}
+}
+
+func NewExternalProvisioner(kernelId string) *ExternalProvisioner {
+	return &ExternalProvisioner{KernelId: kernelId, exited: make(chan struct{})}
+}
+
+func (p *ExternalProvisioner) LaunchKernel(kernelCmd []string, kw map[string]interface{}, connFile string) (KernelConnectionInfo, error) {
+	return nil, fmt.Errorf("cannot launch kernel %s: %w", p.KernelId, ErrNotOwned)
+}
+
+func (p *ExternalProvisioner) SignalKernel(sig os.Signal) error {
+	return fmt.Errorf("cannot send %v to kernel %s: %w", sig, p.KernelId, ErrNotOwned)
+}
+
+// ShutdownKernel detaches from the kernel, which keeps running.
+func (p *ExternalProvisioner) ShutdownKernel() error {
+	return nil
+}
+
+// Exited is never closed, the end of the kernel cannot be observed.
+func (p *ExternalProvisioner) Exited() <-chan struct{} {
+	return p.exited
+}
@@ -0,0 +1,99 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zasper-io/zasper/internal/kernelspec"
+)
+
+// CgroupParent is a cgroup v2 directory delegated to Zasper, e.g. by systemd
+// with Delegate=yes, under which the cgroups of kernels are created. When
+// empty, the cgroup of the server is used if it allows it.
+var CgroupParent string
+
+// ResourceLimits bound the resources a kernel process tree may use. Zero
+// values mean unlimited.
+type ResourceLimits struct {
+	MemoryMax MemorySize `json:"memory_max"`
+	// CPUQuota is the number of CPUs the kernel may use, e.g. 1.5
+	CPUQuota float64 `json:"cpu_quota"`
+	PidsMax  int64   `json:"pids_max"`
+}
+
+func (limits ResourceLimits) IsZero() bool {
+	return limits == ResourceLimits{}
+}
+
+// Merge returns limits with the fields set in override replaced.
+func (limits ResourceLimits) Merge(override ResourceLimits) ResourceLimits {
+	if override.MemoryMax > 0 {
+		limits.MemoryMax = override.MemoryMax
+	}
+	if override.CPUQuota > 0 {
+		limits.CPUQuota = override.CPUQuota
+	}
+	if override.PidsMax > 0 {
+		limits.PidsMax = override.PidsMax
+	}
+	return limits
+}
+
+// LimitsFromKernelspec reads the "resource_limits" object of the kernelspec
+// metadata, e.g. {"memory_max": "4G", "cpu_quota": 2, "pids_max": 512}.
+func LimitsFromKernelspec(spec kernelspec.KernelSpecJsonData) (ResourceLimits, error) {
+	if spec.Metadata == nil {
+		return ResourceLimits{}, nil
+	}
+	data, err := json.Marshal(spec.Metadata)
+	if err != nil {
+		return ResourceLimits{}, err
+	}
+	var metadata struct {
+		ResourceLimits ResourceLimits `json:"resource_limits"`
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return ResourceLimits{}, fmt.Errorf("invalid resource_limits in kernelspec %s: %w", spec.Name, err)
+	}
+	return metadata.ResourceLimits, nil
+}
+
+// MemorySize is a number of bytes, written in JSON either as a number or as a
+// string with an optional K, M, G or T suffix.
+type MemorySize int64
+
+func (size *MemorySize) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		s = string(data)
+	}
+	parsed, err := ParseMemorySize(s)
+	if err != nil {
+		return err
+	}
+	*size = parsed
+	return nil
+}
+
+// ParseMemorySize parses sizes like "512M" or "4G" using binary units.
+func ParseMemorySize(s string) (MemorySize, error) {
+	number := strings.TrimSuffix(strings.TrimSpace(strings.ToUpper(s)), "B")
+	if number == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	for i, unit := range []string{"K", "M", "G", "T"} {
+		if trimmed, ok := strings.CutSuffix(strings.TrimSuffix(number, "I"), unit); ok {
+			multiplier = int64(1) << (10 * (i + 1))
+			number = trimmed
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid memory size %q", s)
+	}
+	return MemorySize(value * float64(multiplier)), nil
+}
@@ -0,0 +1,226 @@
+package provisioner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+const cgroupMount = "/sys/fs/cgroup"
+
+// cpuPeriod is the cpu.max period in microseconds.
+const cpuPeriod = 100000
+
+// kernelLimiter confines a kernel process tree to its ResourceLimits in a
+// cgroup v2 subtree. Without cgroups v2, memory_max falls back to an
+// RLIMIT_DATA set before the kernel is executed, per process: an address
+// space limit would break numpy and JIT runtimes. cpu_quota and pids_max
+// have no per-process equivalent and are not enforced.
+type kernelLimiter struct {
+	kernelId string
+	limits   ResourceLimits
+
+	cgroupDir string
+	cgroupFd  int
+	// rlimit is set when the cgroup could not be created
+	rlimit bool
+}
+
+func newKernelLimiter(kernelId string, limits ResourceLimits) *kernelLimiter {
+	return &kernelLimiter{kernelId: kernelId, limits: limits, cgroupFd: -1}
+}
+
+// prepare creates the cgroup of the kernel so that the process is started
+// directly inside it.
+func (l *kernelLimiter) prepare(attr *syscall.SysProcAttr) {
+	if l.limits.IsZero() {
+		return
+	}
+	dir, err := createKernelCgroup(l.kernelId, l.limits)
+	if err != nil {
+		l.fallBack(err)
+		return
+	}
+	fd, err := unix.Open(dir, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		os.Remove(dir)
+		l.fallBack(fmt.Errorf("cannot open cgroup %s: %w", dir, err))
+		return
+	}
+	l.cgroupDir = dir
+	l.cgroupFd = fd
+	attr.UseCgroupFD = true
+	attr.CgroupFD = fd
+	log.Info().Msgf("kernel %s limited by cgroup %s", l.kernelId, dir)
+}
+
+func (l *kernelLimiter) fallBack(err error) {
+	l.rlimit = true
+	if l.limits.CPUQuota > 0 || l.limits.PidsMax > 0 {
+		log.Error().Msgf("cpu_quota and pids_max of kernel %s are NOT enforced without cgroups v2: %v", l.kernelId, err)
+	}
+	if l.limits.MemoryMax > 0 {
+		log.Warn().Msgf("memory_max of kernel %s is enforced per process with RLIMIT_DATA, without cgroups v2: %v", l.kernelId, err)
+	}
+}
+
+// wrap returns the command running kernelCmd within the rlimit fallback.
+// The limit is set by a shell which then executes the kernel, so that it
+// applies from the start, before the kernel allocates anything.
+func (l *kernelLimiter) wrap(kernelCmd []string) []string {
+	if !l.rlimit || l.limits.MemoryMax <= 0 {
+		return kernelCmd
+	}
+	kib := max(int64(l.limits.MemoryMax)/1024, 1)
+	script := fmt.Sprintf(`ulimit -d %d && exec "$@"`, kib)
+	return append([]string{"/bin/sh", "-c", script, "sh"}, kernelCmd...)
+}
+
+// started closes the cgroup of the kernel, which the process now is in.
+func (l *kernelLimiter) started(pid int) {
+	if l.cgroupFd >= 0 {
+		unix.Close(l.cgroupFd)
+		l.cgroupFd = -1
+	}
+}
+
+// release kills whatever is left of the process tree and removes the cgroup.
+func (l *kernelLimiter) release() {
+	if l.cgroupFd >= 0 {
+		unix.Close(l.cgroupFd)
+		l.cgroupFd = -1
+	}
+	if l.cgroupDir == "" {
+		return
+	}
+	os.WriteFile(filepath.Join(l.cgroupDir, "cgroup.kill"), []byte("1"), 0)
+	for i := 0; i < 50; i++ {
+		if err := os.Remove(l.cgroupDir); err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	log.Warn().Msgf("cannot remove cgroup %s", l.cgroupDir)
+}
+
+// CheckLimits tells whether limits can be enforced on kernels, so that the
+// server warns at startup when they cannot.
+func CheckLimits(limits ResourceLimits) error {
+	if limits.IsZero() {
+		return nil
+	}
+	if _, err := kernelCgroupParent(); err != nil {
+		return fmt.Errorf("kernel resource limits need cgroups v2: memory_max falls back to RLIMIT_DATA, cpu_quota and pids_max are not enforced: %w", err)
+	}
+	return nil
+}
+
+func createKernelCgroup(kernelId string, limits ResourceLimits) (string, error) {
+	parent, err := kernelCgroupParent()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(parent, "kernel-"+kernelId)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", err
+	}
+
+	settings := map[string]string{}
+	if limits.MemoryMax > 0 {
+		settings["memory.max"] = strconv.FormatInt(int64(limits.MemoryMax), 10)
+		settings["memory.swap.max"] = "0"
+	}
+	if limits.CPUQuota > 0 {
+		settings["cpu.max"] = fmt.Sprintf("%d %d", int64(limits.CPUQuota*cpuPeriod), cpuPeriod)
+	}
+	if limits.PidsMax > 0 {
+		settings["pids.max"] = strconv.FormatInt(limits.PidsMax, 10)
+	}
+	for file, value := range settings {
+		err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0)
+		// memory.swap.max is missing when swap accounting is off
+		if err != nil && file != "memory.swap.max" {
+			os.Remove(dir)
+			return "", fmt.Errorf("writing %s: %w", file, err)
+		}
+	}
+	return dir, nil
+}
+
+var (
+	cgroupParentOnce sync.Once
+	cgroupParent     string
+	cgroupParentErr  error
+)
+
+// kernelCgroupParent returns the cgroup under which kernel cgroups are
+// created, with the memory, cpu and pids controllers enabled for its
+// children: CgroupParent, or else the cgroup of the server itself. The
+// server is never moved to another cgroup, so when its own cgroup holds
+// processes, which forbids enabling controllers, kernels are not limited.
+func kernelCgroupParent() (string, error) {
+	cgroupParentOnce.Do(func() {
+		parent := CgroupParent
+		if parent == "" {
+			parent, cgroupParentErr = ownCgroup()
+			if cgroupParentErr != nil {
+				return
+			}
+		}
+		cgroupParent, cgroupParentErr = setUpCgroupParent(parent)
+	})
+	return cgroupParent, cgroupParentErr
+}
+
+func ownCgroup() (string, error) {
+	own, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(own), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return filepath.Join(cgroupMount, path), nil
+		}
+	}
+	return "", errors.New("the server is not in a cgroup v2 hierarchy")
+}
+
+// setUpCgroupParent enables the controllers needed by kernel cgroups in the
+// subtree of parent.
+func setUpCgroupParent(parent string) (string, error) {
+	controllers, err := os.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return "", fmt.Errorf("%s is not a cgroup v2: %w", parent, err)
+	}
+	enabled, _ := os.ReadFile(filepath.Join(parent, "cgroup.subtree_control"))
+	missing := []string{}
+	for _, controller := range []string{"memory", "cpu", "pids"} {
+		if !hasController(controllers, controller) {
+			return "", fmt.Errorf("the %s controller is not available in %s", controller, parent)
+		}
+		if !hasController(enabled, controller) {
+			missing = append(missing, "+"+controller)
+		}
+	}
+	if len(missing) == 0 {
+		return parent, nil
+	}
+	err = os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte(strings.Join(missing, " ")), 0)
+	if err != nil {
+		return "", fmt.Errorf("cannot enable controllers in %s, delegate a cgroup to Zasper with -kernel-cgroup: %w", parent, err)
+	}
+	return parent, nil
+}
+
+func hasController(list []byte, controller string) bool {
+	return strings.Contains(" "+strings.TrimSpace(string(list))+" ", " "+controller+" ")
+}
@@ -0,0 +1,100 @@
+package provisioner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLaunchKernelWithLimits(t *testing.T) {
+	p := &LocalProvisioner{
+		KernelId: "limits-test",
+		Limits:   ResourceLimits{MemoryMax: 1 << 30},
+	}
+	_, err := p.LaunchKernel([]string{"sleep", "5"}, nil, "")
+	require.NoError(t, err)
+	defer p.ShutdownKernel()
+
+	cgroup, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", p.ProcessId()))
+	require.NoError(t, err)
+	if strings.Contains(string(cgroup), "kernel-limits-test") {
+		return
+	}
+
+	// without cgroups v2 the data segment is limited from the start, but
+	// not the address space, which runtimes reserve much more of than used
+	dataLimit := regexp.MustCompile(`Max data size\s+1073741824\s+1073741824`)
+	var limits []byte
+	// the shell sets the limit, then executes the kernel
+	assert.Eventually(t, func() bool {
+		limits, err = os.ReadFile(fmt.Sprintf("/proc/%d/limits", p.ProcessId()))
+		return err == nil && dataLimit.Match(limits)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Regexp(t, `Max address space\s+unlimited`, string(limits))
+}
+
+func TestRlimitFallbackCommand(t *testing.T) {
+	l := newKernelLimiter("fallback", ResourceLimits{MemoryMax: 512 << 20, CPUQuota: 1})
+	kernelCmd := []string{"python3", "-m", "ipykernel_launcher", "-f", "{connection_file}"}
+	assert.Equal(t, kernelCmd, l.wrap(kernelCmd), "the cgroup limits the kernel")
+
+	l.fallBack(errors.New("not delegated"))
+	assert.Equal(t, append([]string{"/bin/sh", "-c", `ulimit -d 524288 && exec "$@"`, "sh"}, kernelCmd...), l.wrap(kernelCmd))
+
+	l = newKernelLimiter("fallback", ResourceLimits{PidsMax: 64})
+	l.fallBack(errors.New("not delegated"))
+	assert.Equal(t, kernelCmd, l.wrap(kernelCmd), "no memory limit to apply")
+}
+
+func TestSetUpCgroupParent(t *testing.T) {
+	tests := []struct {
+		name        string
+		controllers string
+		// subtreeControl is the content of cgroup.subtree_control, or nil
+		// when it cannot be written, as in a cgroup holding processes
+		subtreeControl *string
+		enabled        string
+		err            bool
+	}{
+		{name: "controllers enabled", controllers: "cpu memory pids", subtreeControl: ptr(""), enabled: "+memory +cpu +pids"},
+		{name: "controllers already enabled", controllers: "cpu memory pids", subtreeControl: ptr("cpu memory pids"), enabled: "cpu memory pids"},
+		{name: "controller missing", controllers: "cpu memory", subtreeControl: ptr(""), err: true},
+		{name: "not delegated", controllers: "cpu memory pids", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(parent, "cgroup.controllers"), []byte(tt.controllers), 0644))
+			subtreeControl := filepath.Join(parent, "cgroup.subtree_control")
+			if tt.subtreeControl != nil {
+				require.NoError(t, os.WriteFile(subtreeControl, []byte(*tt.subtreeControl), 0644))
+			} else {
+				require.NoError(t, os.Mkdir(subtreeControl, 0755))
+			}
+
+			dir, err := setUpCgroupParent(parent)
+			if tt.err {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, parent, dir)
+				enabled, _ := os.ReadFile(subtreeControl)
+				assert.Equal(t, tt.enabled, string(enabled))
+			}
+			// the server stays where it is
+			assert.NoDirExists(t, filepath.Join(parent, "server"))
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
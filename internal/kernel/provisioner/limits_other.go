@@ -0,0 +1,44 @@
+//go:build !linux
+
+package provisioner
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+// kernelLimiter only warns: resource limits need cgroups v2, which only
+// Linux has.
+type kernelLimiter struct {
+	kernelId string
+	limits   ResourceLimits
+}
+
+func newKernelLimiter(kernelId string, limits ResourceLimits) *kernelLimiter {
+	return &kernelLimiter{kernelId: kernelId, limits: limits}
+}
+
+func (l *kernelLimiter) prepare(attr *syscall.SysProcAttr) {
+	if !l.limits.IsZero() {
+		log.Warn().Msgf("resource limits of kernel %s are only enforced on Linux", l.kernelId)
+	}
+}
+
+func (l *kernelLimiter) wrap(kernelCmd []string) []string {
+	return kernelCmd
+}
+
+func (l *kernelLimiter) started(pid int) {}
+
+// CheckLimits tells whether limits can be enforced on kernels, so that the
+// server warns at startup when they cannot.
+func CheckLimits(limits ResourceLimits) error {
+	if limits.IsZero() {
+		return nil
+	}
+	return errors.New("kernel resource limits are only enforced on Linux")
+}
+
+func (l *kernelLimiter) release() {}
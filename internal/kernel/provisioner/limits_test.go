@@ -0,0 +1,51 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zasper-io/zasper/internal/kernelspec"
+)
+
+func TestParseMemorySize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected MemorySize
+	}{
+		{"", 0},
+		{"1024", 1024},
+		{"512M", 512 << 20},
+		{"4G", 4 << 30},
+		{"4gb", 4 << 30},
+		{"1.5GiB", 3 << 29},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			size, err := ParseMemorySize(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, size)
+		})
+	}
+
+	_, err := ParseMemorySize("lots")
+	assert.Error(t, err)
+}
+
+func TestLimitsFromKernelspec(t *testing.T) {
+	spec := kernelspec.KernelSpecJsonData{
+		Metadata: map[string]interface{}{
+			"resource_limits": map[string]interface{}{"memory_max": "2G", "pids_max": 64},
+		},
+	}
+	limits, err := LimitsFromKernelspec(spec)
+	require.NoError(t, err)
+	assert.Equal(t, ResourceLimits{MemoryMax: 2 << 30, PidsMax: 64}, limits)
+
+	server := ResourceLimits{MemoryMax: 8 << 30, CPUQuota: 2}
+	assert.Equal(t, ResourceLimits{MemoryMax: 2 << 30, CPUQuota: 2, PidsMax: 64}, server.Merge(limits))
+
+	limits, err = LimitsFromKernelspec(kernelspec.KernelSpecJsonData{})
+	require.NoError(t, err)
+	assert.True(t, limits.IsZero())
+}
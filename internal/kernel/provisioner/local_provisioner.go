@@ -1,6 +1,13 @@
 package provisioner
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
 	"github.com/zasper-io/zasper/internal/kernel/launcher"
 	"github.com/zasper-io/zasper/internal/kernelspec"
 
@@ -19,20 +26,108 @@ type LocalProvisioner struct {
 	Pgid           int
 	IP             string
 	PortsCached    bool
+	Stdout         io.Writer
+	Stderr         io.Writer
+	Limits         ResourceLimits
+
+	mu      sync.Mutex
+	exited  chan struct{}
+	exitErr error
 }
 
 func (provisioner *LocalProvisioner) LaunchKernel(kernelCmd []string, kw map[string]interface{}, connFile string) (KernelConnectionInfo, error) {
-	process, err := launcher.LaunchKernel(kernelCmd, kw, connFile)
+	stdout, stderr := provisioner.Stdout, provisioner.Stderr
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	limiter := newKernelLimiter(provisioner.KernelId, provisioner.Limits)
+	attr := &syscall.SysProcAttr{}
+	setProcessGroup(attr)
+	limiter.prepare(attr)
+	cmd, err := launcher.LaunchKernel(limiter.wrap(kernelCmd), kw, connFile, stdout, stderr, attr)
 	if err != nil {
+		limiter.release()
 		return nil, err
 	}
+	limiter.started(cmd.Process.Pid)
+
+	exited := make(chan struct{})
+	provisioner.mu.Lock()
+	provisioner.Pid = cmd.Process.Pid
+	provisioner.Pgid = processGroup(cmd.Process.Pid)
+	provisioner.exited = exited
+	provisioner.mu.Unlock()
+
+	go func() {
+		err := cmd.Wait()
+		limiter.release()
+		provisioner.mu.Lock()
+		provisioner.exitErr = err
+		provisioner.mu.Unlock()
+		log.Info().Msgf("kernel %s (pid %d) exited: %v", provisioner.KernelId, cmd.Process.Pid, err)
+		close(exited)
+	}()
 
-	provisioner.Pid = process.Pid
-	log.Debug().Msgf("kernel launched with pid: %d", process.Pid)
+	log.Debug().Msgf("kernel launched with pid: %d", cmd.Process.Pid)
 	return provisioner.ConnectionInfo, nil
 }
 
-func (provisioner *LocalProvisioner) ShutdownKernel() {
-	log.Info().Msgf("Shutting down kernel with pid: %d", provisioner.Pid)
-	launcher.ShutdownKernel(provisioner.Pid)
+func (provisioner *LocalProvisioner) Exited() <-chan struct{} {
+	provisioner.mu.Lock()
+	defer provisioner.mu.Unlock()
+	if provisioner.exited == nil {
+		provisioner.exited = make(chan struct{})
+	}
+	return provisioner.exited
+}
+
+// ExitError returns the result of waiting for the kernel process.
+func (provisioner *LocalProvisioner) ExitError() error {
+	provisioner.mu.Lock()
+	defer provisioner.mu.Unlock()
+	return provisioner.exitErr
+}
+
+// ProcessId returns the pid of the kernel process, or 0 before it is launched.
+func (provisioner *LocalProvisioner) ProcessId() int {
+	provisioner.mu.Lock()
+	defer provisioner.mu.Unlock()
+	return provisioner.Pid
+}
+
+func (provisioner *LocalProvisioner) processGroup() (int, int) {
+	provisioner.mu.Lock()
+	defer provisioner.mu.Unlock()
+	return provisioner.Pid, provisioner.Pgid
+}
+
+// SignalKernel sends sig to the process group of the kernel, which includes
+// the subprocesses it started.
+func (provisioner *LocalProvisioner) SignalKernel(sig os.Signal) error {
+	pid, pgid := provisioner.processGroup()
+	if pid == 0 {
+		return fmt.Errorf("kernel %s has no process", provisioner.KernelId)
+	}
+	if err := signalProcessGroup(pid, pgid, sig); err != nil {
+		return fmt.Errorf("Failed to send %v to process group %d: %v", sig, pgid, err)
+	}
+	return nil
+}
+
+// ShutdownKernel kills the kernel and every process of its process group.
+func (provisioner *LocalProvisioner) ShutdownKernel() error {
+	pid, pgid := provisioner.processGroup()
+	if pid == 0 {
+		return nil
+	}
+	log.Info().Msgf("Shutting down kernel with pid: %d", pid)
+	err := signalProcessGroup(pid, pgid, os.Kill)
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		log.Error().Msgf("Error killing process group %d: %v", pgid, err)
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,65 @@
+//go:build !windows
+
+package provisioner
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// isRunning reports whether pid is alive, ignoring zombies.
+func isRunning(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return syscall.Kill(pid, 0) == nil
+	}
+	return !strings.Contains(string(stat), ") Z ")
+}
+
+func TestSignalReachesProcessGroup(t *testing.T) {
+	stdout := &syncBuffer{}
+	p := &LocalProvisioner{KernelId: "group-test", Stdout: stdout}
+	_, err := p.LaunchKernel([]string{"sh", "-c", "sleep 30 & echo $!; wait"}, nil, "")
+	require.NoError(t, err)
+	defer p.ShutdownKernel()
+
+	assert.Equal(t, p.Pid, p.Pgid)
+
+	var child int
+	require.Eventually(t, func() bool {
+		child, err = strconv.Atoi(strings.TrimSpace(stdout.String()))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, p.SignalKernel(syscall.SIGTERM))
+
+	assert.Eventually(t, func() bool { return !isRunning(child) }, 5*time.Second, 10*time.Millisecond)
+	<-p.Exited()
+}
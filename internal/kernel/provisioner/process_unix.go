@@ -0,0 +1,38 @@
+//go:build !windows
+
+package provisioner
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// setProcessGroup starts the kernel in a new process group, so that signals
+// also reach the processes it spawns.
+func setProcessGroup(attr *syscall.SysProcAttr) {
+	attr.Setpgid = true
+}
+
+func processGroup(pid int) int {
+	pgid, err := syscall.Getpgid(pid)
+	if err != nil {
+		return pid
+	}
+	return pgid
+}
+
+func signalProcessGroup(pid, pgid int, sig os.Signal) error {
+	if pgid == 0 {
+		process, err := os.FindProcess(pid)
+		if err != nil {
+			return err
+		}
+		return process.Signal(sig)
+	}
+	err := syscall.Kill(-pgid, sig.(syscall.Signal))
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
@@ -0,0 +1,26 @@
+package provisioner
+
+import (
+	"os"
+	"syscall"
+)
+
+// setProcessGroup starts the kernel in a new process group, so that console
+// control events for the server do not reach it.
+func setProcessGroup(attr *syscall.SysProcAttr) {
+	attr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+func processGroup(pid int) int {
+	return pid
+}
+
+// signalProcessGroup can only signal the kernel process itself, as Windows
+// has no process group signals.
+func signalProcessGroup(pid, pgid int, sig os.Signal) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Signal(sig)
+}
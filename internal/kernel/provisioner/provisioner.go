@@ -0,0 +1,42 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/zasper-io/zasper/internal/kernelspec"
+)
+
+// Provisioner launches and controls the process behind a kernel.
+type Provisioner interface {
+	LaunchKernel(kernelCmd []string, kw map[string]interface{}, connFile string) (KernelConnectionInfo, error)
+	SignalKernel(sig os.Signal) error
+	ShutdownKernel() error
+	// Exited is closed once the kernel has terminated.
+	Exited() <-chan struct{}
+}
+
+// ProvisionerName returns the kernel_provisioner named in the metadata of the
+// kernelspec, or "" for local kernels.
+func ProvisionerName(spec kernelspec.KernelSpecJsonData) string {
+	var metadata struct {
+		KernelProvisioner struct {
+			ProvisionerName string `json:"provisioner_name"`
+		} `json:"kernel_provisioner"`
+	}
+	if err := decodeMetadata(spec, &metadata); err != nil {
+		return ""
+	}
+	return metadata.KernelProvisioner.ProvisionerName
+}
+
+func decodeMetadata(spec kernelspec.KernelSpecJsonData, v interface{}) error {
+	if spec.Metadata == nil {
+		return nil
+	}
+	data, err := json.Marshal(spec.Metadata)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
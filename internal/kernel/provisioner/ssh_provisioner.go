@@ -0,0 +1,590 @@
+package provisioner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/zasper-io/zasper/internal/kernelspec"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// RemoteStartupTimeout is how long a remote kernel has to bind its ports.
+var RemoteStartupTimeout = 30 * time.Second
+
+// channelPorts are the keys of the ports of a connection file.
+var channelPorts = []string{"shell_port", "iopub_port", "stdin_port", "control_port", "hb_port"}
+
+// SSHConfig is the "config" of a kernelspec whose kernel_provisioner is
+// "ssh", e.g.
+//
+//	"metadata": {"kernel_provisioner": {"provisioner_name": "ssh",
+//	    "config": {"host": "gpu-box", "user": "me", "cwd": "/data"}}}
+type SSHConfig struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	User string `json:"user"`
+	// IdentityFile defaults to the usual keys in ~/.ssh, in addition to
+	// those of the ssh agent.
+	IdentityFile string `json:"identity_file"`
+	// KnownHosts defaults to ~/.ssh/known_hosts.
+	KnownHosts string `json:"known_hosts"`
+	// RuntimeDir is the remote directory of the connection file.
+	RuntimeDir string `json:"runtime_dir"`
+	// Cwd is the remote working directory of the kernel, its home
+	// directory by default.
+	Cwd string `json:"cwd"`
+}
+
+func sshConfigFromKernelspec(spec kernelspec.KernelSpecJsonData) (SSHConfig, error) {
+	var metadata struct {
+		KernelProvisioner struct {
+			Config SSHConfig `json:"config"`
+		} `json:"kernel_provisioner"`
+	}
+	if err := decodeMetadata(spec, &metadata); err != nil {
+		return SSHConfig{}, fmt.Errorf("invalid kernel_provisioner in kernelspec %s: %w", spec.Name, err)
+	}
+	config := metadata.KernelProvisioner.Config
+	if config.Host == "" {
+		return config, fmt.Errorf("kernelspec %s: the ssh provisioner needs a host", spec.Name)
+	}
+	if config.Port == 0 {
+		config.Port = 22
+	}
+	if config.User == "" {
+		config.User = os.Getenv("USER")
+	}
+	if config.RuntimeDir == "" {
+		config.RuntimeDir = "/tmp"
+	}
+	return config, nil
+}
+
+// SSHProvisioner runs the kernel on a remote host over SSH. The connection
+// file is written on the remote host with ports left to the kernel, and the
+// ports it binds are forwarded to the local ports of the connection info, so
+// that the channels are reached on 127.0.0.1 as for a local kernel.
+type SSHProvisioner struct {
+	Kernelspec kernelspec.KernelSpecJsonData
+	KernelId   string
+	Config     SSHConfig
+	Stdout     io.Writer
+	Stderr     io.Writer
+
+	configErr error
+
+	mu         sync.Mutex
+	client     *ssh.Client
+	listeners  []net.Listener
+	remotePid  int
+	remoteFile string
+	closed     bool
+	// shuttingDown is set while ShutdownKernel still needs the connection
+	shuttingDown bool
+	exited       chan struct{}
+	exitErr      error
+}
+
+func NewSSHProvisioner(kernelId string, spec kernelspec.KernelSpecJsonData, stdout, stderr io.Writer) *SSHProvisioner {
+	config, err := sshConfigFromKernelspec(spec)
+	return &SSHProvisioner{
+		Kernelspec: spec,
+		KernelId:   kernelId,
+		Config:     config,
+		Stdout:     stdout,
+		Stderr:     stderr,
+		configErr:  err,
+	}
+}
+
+func (p *SSHProvisioner) LaunchKernel(kernelCmd []string, kw map[string]interface{}, connFile string) (KernelConnectionInfo, error) {
+	if p.configErr != nil {
+		return nil, p.configErr
+	}
+	if p.Stdout == nil {
+		p.Stdout = os.Stdout
+	}
+	if p.Stderr == nil {
+		p.Stderr = os.Stderr
+	}
+	localInfo, err := readConnectionFile(connFile)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dialSSH(p.Config)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.client = client
+	p.shuttingDown = false
+	p.exited = make(chan struct{})
+	p.mu.Unlock()
+
+	remoteFile, err := p.writeRemoteConnectionFile(localInfo)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	sessionEnv, _ := kw["session_env"].([]string)
+	session, pid, err := p.startRemoteKernel(kernelCmd, remoteFile, sessionEnv)
+	if err != nil {
+		p.runRemote("rm -f " + shellQuote(remoteFile))
+		client.Close()
+		return nil, err
+	}
+	p.mu.Lock()
+	p.remotePid = pid
+	p.remoteFile = remoteFile
+	p.mu.Unlock()
+	log.Info().Msgf("kernel %s launched on %s with pid %d", p.KernelId, p.Config.Host, pid)
+
+	exited := p.exited
+	go func() {
+		err := session.Wait()
+		p.mu.Lock()
+		p.exitErr = err
+		p.closed = true
+		for _, listener := range p.listeners {
+			listener.Close()
+		}
+		shuttingDown := p.shuttingDown
+		p.mu.Unlock()
+		if !shuttingDown {
+			// the kernel exited by itself, its key must not stay on the host
+			p.runRemote("rm -f " + shellQuote(remoteFile))
+			client.Close()
+		}
+		log.Info().Msgf("kernel %s on %s exited: %v", p.KernelId, p.Config.Host, err)
+		close(exited)
+	}()
+
+	remoteInfo, err := p.waitForPorts(remoteFile)
+	if err != nil {
+		p.ShutdownKernel()
+		return nil, err
+	}
+	if err := p.forwardPorts(localInfo, remoteInfo); err != nil {
+		p.ShutdownKernel()
+		return nil, err
+	}
+	return localInfo, nil
+}
+
+func readConnectionFile(path string) (KernelConnectionInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info := KernelConnectionInfo{}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("invalid connection file %s: %w", path, err)
+	}
+	return info, nil
+}
+
+func dialSSH(config SSHConfig) (*ssh.Client, error) {
+	home, _ := os.UserHomeDir()
+	knownHostsFile := config.KnownHosts
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	} else if strings.HasPrefix(knownHostsFile, "~/") {
+		knownHostsFile = filepath.Join(home, knownHostsFile[2:])
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read known hosts: %w", err)
+	}
+
+	auth, closeAgent := sshAuthMethods(config, home)
+	defer closeAgent()
+	clientConfig := &ssh.ClientConfig{
+		User:            config.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         RemoteStartupTimeout,
+	}
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	client, err := ssh.Dial("tcp", addr, clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to %s: %w", addr, err)
+	}
+	return client, nil
+}
+
+// sshAuthMethods offers the keys of the ssh agent, then the identity file or
+// the default keys. Keys protected by a passphrase can only be used through
+// the agent.
+func sshAuthMethods(config SSHConfig, home string) ([]ssh.AuthMethod, func()) {
+	methods := []ssh.AuthMethod{}
+	closeAgent := func() {}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			closeAgent = func() { conn.Close() }
+		}
+	}
+
+	files := []string{config.IdentityFile}
+	if strings.HasPrefix(config.IdentityFile, "~/") {
+		files = []string{filepath.Join(home, config.IdentityFile[2:])}
+	}
+	if config.IdentityFile == "" {
+		files = []string{}
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			files = append(files, filepath.Join(home, ".ssh", name))
+		}
+	}
+	signers := []ssh.Signer{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			log.Warn().Msgf("cannot use ssh key %s: %v", file, err)
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	return methods, closeAgent
+}
+
+// runRemote runs a command on the remote host and returns its output.
+func (p *SSHProvisioner) runRemote(cmd string) ([]byte, error) {
+	return p.runRemoteWithInput(cmd, nil)
+}
+
+func (p *SSHProvisioner) runRemoteWithInput(cmd string, stdin io.Reader) ([]byte, error) {
+	p.mu.Lock()
+	client := p.client
+	p.mu.Unlock()
+	if client == nil {
+		return nil, errors.New("not connected")
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	session.Stdin = stdin
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	out, err := session.Output(cmd)
+	if err != nil {
+		return out, fmt.Errorf("%s: %w: %s", cmd, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// writeRemoteConnectionFile writes the connection file for the remote kernel,
+// with the key of the local one and the ports left for the kernel to choose.
+func (p *SSHProvisioner) writeRemoteConnectionFile(localInfo KernelConnectionInfo) (string, error) {
+	remoteInfo := KernelConnectionInfo{}
+	for k, v := range localInfo {
+		remoteInfo[k] = v
+	}
+	remoteInfo["ip"] = "127.0.0.1"
+	remoteInfo["transport"] = "tcp"
+	for _, port := range channelPorts {
+		remoteInfo[port] = 0
+	}
+	data, err := json.Marshal(remoteInfo)
+	if err != nil {
+		return "", err
+	}
+
+	remoteFile := strings.TrimSuffix(p.Config.RuntimeDir, "/") + "/kernel-" + p.KernelId + ".json"
+	cmd := fmt.Sprintf("umask 077 && mkdir -p %s && cat > %s", shellQuote(p.Config.RuntimeDir), shellQuote(remoteFile))
+	if _, err := p.runRemoteWithInput(cmd, bytes.NewReader(data)); err != nil {
+		return "", fmt.Errorf("cannot write the connection file on %s: %w", p.Config.Host, err)
+	}
+	return remoteFile, nil
+}
+
+// startRemoteKernel starts the kernel in a session which lasts as long as the
+// kernel. The shell prints its pid before being replaced by the kernel.
+func (p *SSHProvisioner) startRemoteKernel(kernelCmd []string, remoteFile string, sessionEnv []string) (*ssh.Session, int, error) {
+	p.mu.Lock()
+	client := p.client
+	p.mu.Unlock()
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, 0, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, 0, err
+	}
+	session.Stderr = p.Stderr
+
+	if err := session.Start(remoteKernelCommand(kernelCmd, remoteFile, p.Kernelspec.Env, sessionEnv, p.Config.Cwd)); err != nil {
+		session.Close()
+		return nil, 0, err
+	}
+
+	reader := bufio.NewReader(stdout)
+	line, err := reader.ReadString('\n')
+	pid, convErr := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || convErr != nil {
+		session.Close()
+		return nil, 0, fmt.Errorf("kernel did not start on %s: %q", p.Config.Host, strings.TrimSpace(line))
+	}
+	go io.Copy(p.Stdout, reader)
+	return session, pid, nil
+}
+
+// remoteKernelCommand is the shell command starting the kernel. Values of the
+// kernelspec env are double quoted, so that ${VAR} refers to the remote
+// environment.
+func remoteKernelCommand(kernelCmd []string, remoteFile string, specEnv map[string]string, sessionEnv []string, cwd string) string {
+	var cmd strings.Builder
+	if cwd != "" {
+		cmd.WriteString("cd " + shellQuote(cwd) + " && ")
+	}
+	cmd.WriteString("echo $$ && exec")
+
+	var assignments []string
+	names := make([]string, 0, len(specEnv))
+	for name := range specEnv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// kernelspec values may reference remote variables as ${NAME}
+		if remoteEnvName.MatchString(name) {
+			assignments = append(assignments, name+"="+doubleQuote(specEnv[name]))
+		}
+	}
+	for _, kv := range sessionEnv {
+		// session values were expanded by the server, they are sent as is
+		if name, value, ok := strings.Cut(kv, "="); ok && remoteEnvName.MatchString(name) {
+			assignments = append(assignments, name+"="+shellQuote(value))
+		}
+	}
+	if len(assignments) > 0 {
+		cmd.WriteString(" env " + strings.Join(assignments, " "))
+	}
+	for _, arg := range kernelCmd {
+		if arg == "{connection_file}" {
+			arg = remoteFile
+		}
+		cmd.WriteString(" " + shellQuote(arg))
+	}
+	return cmd.String()
+}
+
+// waitForPorts reads the remote connection file until the kernel has written
+// the ports it bound.
+func (p *SSHProvisioner) waitForPorts(remoteFile string) (KernelConnectionInfo, error) {
+	deadline := time.Now().Add(RemoteStartupTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-p.Exited():
+			return nil, errors.New("remote kernel exited during startup")
+		default:
+		}
+		out, err := p.runRemote("cat " + shellQuote(remoteFile))
+		if err == nil {
+			info := KernelConnectionInfo{}
+			if json.Unmarshal(out, &info) == nil && portsBound(info) {
+				return info, nil
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return nil, fmt.Errorf("remote kernel did not write its ports to %s within %s", remoteFile, RemoteStartupTimeout)
+}
+
+func portsBound(info KernelConnectionInfo) bool {
+	for _, port := range channelPorts {
+		if n, ok := info[port].(float64); !ok || n == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// forwardPorts listens on the local port of every channel and forwards the
+// connections to the remote port through the SSH connection.
+func (p *SSHProvisioner) forwardPorts(localInfo KernelConnectionInfo, remoteInfo KernelConnectionInfo) error {
+	ip, _ := localInfo["ip"].(string)
+	for _, port := range channelPorts {
+		localPort, _ := localInfo[port].(float64)
+		remotePort, _ := remoteInfo[port].(float64)
+		listener, err := net.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(int(localPort))))
+		if err != nil {
+			return fmt.Errorf("cannot forward %s: %w", port, err)
+		}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			listener.Close()
+			return errors.New("remote kernel exited during startup")
+		}
+		p.listeners = append(p.listeners, listener)
+		client := p.client
+		p.mu.Unlock()
+		go forward(listener, client, net.JoinHostPort("127.0.0.1", strconv.Itoa(int(remotePort))))
+		log.Debug().Msgf("forwarding %s %s to %v on %s", port, listener.Addr(), remotePort, p.Config.Host)
+	}
+	return nil
+}
+
+func forward(listener net.Listener, client *ssh.Client, remoteAddr string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			remote, err := client.Dial("tcp", remoteAddr)
+			if err != nil {
+				log.Debug().Msgf("cannot reach %s through the tunnel: %v", remoteAddr, err)
+				return
+			}
+			defer remote.Close()
+			done := make(chan struct{}, 2)
+			go func() { io.Copy(remote, conn); done <- struct{}{} }()
+			go func() { io.Copy(conn, remote); done <- struct{}{} }()
+			<-done
+		}()
+	}
+}
+
+func (p *SSHProvisioner) Exited() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.exited == nil {
+		p.exited = make(chan struct{})
+	}
+	return p.exited
+}
+
+// ExitError returns the result of the SSH session of the kernel.
+func (p *SSHProvisioner) ExitError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.exitErr
+}
+
+// SignalKernel sends sig to the process group of the remote kernel, or to the
+// kernel alone if it does not lead its group.
+func (p *SSHProvisioner) SignalKernel(sig os.Signal) error {
+	p.mu.Lock()
+	pid := p.remotePid
+	p.mu.Unlock()
+	if pid == 0 {
+		return fmt.Errorf("kernel %s has no process", p.KernelId)
+	}
+	name, err := signalName(sig)
+	if err != nil {
+		return err
+	}
+	cmd := fmt.Sprintf("kill -s %s -- -%d 2>/dev/null || kill -s %s %d", name, pid, name, pid)
+	if _, err := p.runRemote(cmd); err != nil {
+		return fmt.Errorf("Failed to send %v to remote kernel %d: %v", sig, pid, err)
+	}
+	return nil
+}
+
+func signalName(sig os.Signal) (string, error) {
+	switch sig {
+	case os.Interrupt:
+		return "INT", nil
+	case os.Kill:
+		return "KILL", nil
+	case syscall.SIGTERM:
+		return "TERM", nil
+	}
+	return "", fmt.Errorf("cannot send %v to a remote kernel", sig)
+}
+
+// ShutdownKernel removes the connection file of the remote kernel, kills it
+// and closes the SSH connection. The file is removed first, since the
+// connection may be lost once the kernel is killed.
+func (p *SSHProvisioner) ShutdownKernel() error {
+	p.mu.Lock()
+	client, pid, remoteFile := p.client, p.remotePid, p.remoteFile
+	p.shuttingDown = true
+	p.mu.Unlock()
+	if client == nil {
+		return nil
+	}
+	defer client.Close()
+	log.Info().Msgf("Shutting down kernel %s on %s", p.KernelId, p.Config.Host)
+	var err error
+	if remoteFile != "" {
+		_, err = p.runRemote("rm -f " + shellQuote(remoteFile))
+	}
+	if pid != 0 {
+		if _, killErr := p.runRemote(fmt.Sprintf("kill -s KILL -- -%d 2>/dev/null || kill -s KILL %d", pid, pid)); killErr != nil {
+			err = killErr
+		}
+	}
+	if err != nil {
+		select {
+		case <-p.Exited():
+			// the kernel exiting closed the connection before the reply
+			return nil
+		case <-time.After(shutdownWait):
+		}
+		return err
+	}
+	return nil
+}
+
+// shutdownWait is how long a failed kill waits for the kernel to exit anyway.
+const shutdownWait = 5 * time.Second
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+var remoteEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var remoteEnvReference = regexp.MustCompile(`\$\{[A-Za-z_][A-Za-z0-9_]*\}`)
+
+var doubleQuoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", "$", `\$`)
+
+// doubleQuote quotes s for the remote shell, which only expands the ${NAME}
+// references in it: command substitutions and other $ expansions are
+// escaped.
+func doubleQuote(s string) string {
+	var quoted strings.Builder
+	quoted.WriteString(`"`)
+	last := 0
+	for _, ref := range remoteEnvReference.FindAllStringIndex(s, -1) {
+		quoted.WriteString(doubleQuoteEscaper.Replace(s[last:ref[0]]))
+		quoted.WriteString(s[ref[0]:ref[1]])
+		last = ref[1]
+	}
+	quoted.WriteString(doubleQuoteEscaper.Replace(s[last:]))
+	quoted.WriteString(`"`)
+	return quoted.String()
+}
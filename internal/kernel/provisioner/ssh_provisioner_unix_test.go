@@ -0,0 +1,356 @@
+//go:build !windows
+
+package provisioner
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/zasper-io/zasper/internal/kernelspec"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// fakeRemoteKernel binds the ports of its connection file like ipykernel,
+// writes them back, echoes on the shell port and reports SIGINT on stderr.
+const fakeRemoteKernel = `
+import json, signal, socket, sys, threading
+path = sys.argv[2]
+info = json.load(open(path))
+sockets = {}
+for name in ["shell_port", "iopub_port", "stdin_port", "control_port", "hb_port"]:
+    s = socket.socket()
+    s.bind(("127.0.0.1", 0))
+    s.listen(5)
+    sockets[name] = s
+    info[name] = s.getsockname()[1]
+json.dump(info, open(path, "w"))
+signal.signal(signal.SIGINT, lambda *args: print("interrupted", file=sys.stderr, flush=True))
+def echo(s):
+    while True:
+        conn, _ = s.accept()
+        data = conn.recv(1024)
+        conn.sendall(data)
+        conn.close()
+threading.Thread(target=echo, args=(sockets["shell_port"],), daemon=True).start()
+while True:
+    signal.pause()
+`
+
+// startSSHServer runs an SSH server accepting key, which runs commands with
+// sh and forwards direct-tcpip channels, as sshd does.
+func startSSHServer(t *testing.T, key ssh.PublicKey) (string, ssh.PublicKey) {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	require.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, offered ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(offered.Marshal(), key.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+	return listener.Addr().String(), hostSigner.PublicKey()
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			go serveSession(newChannel)
+		case "direct-tcpip":
+			go serveDirectTCPIP(newChannel)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+func serveSession(newChannel ssh.NewChannel) {
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	for req := range reqs {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		ssh.Unmarshal(req.Payload, &payload)
+		req.Reply(true, nil)
+
+		cmd := exec.Command("sh", "-c", payload.Command)
+		cmd.Stdin = channel
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		go func() {
+			status := 0
+			if err := cmd.Run(); err != nil {
+				status = 1
+				if exitErr, ok := err.(*exec.ExitError); ok {
+					status = exitErr.ExitCode()
+				}
+			}
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+			channel.Close()
+		}()
+	}
+}
+
+func serveDirectTCPIP(newChannel ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	ssh.Unmarshal(newChannel.ExtraData(), &payload)
+	conn, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() { io.Copy(channel, conn); channel.Close() }()
+	go func() { io.Copy(conn, channel); conn.Close() }()
+}
+
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// setUpSSH returns the kernelspec of a kernel run through a local SSH server.
+func setUpSSH(t *testing.T) (kernelspec.KernelSpecJsonData, string) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is needed for the fake kernel")
+	}
+	t.Setenv("SSH_AUTH_SOCK", "")
+	dir := t.TempDir()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	require.NoError(t, err)
+	identityFile := filepath.Join(dir, "id_ed25519")
+	require.NoError(t, os.WriteFile(identityFile, pem.EncodeToMemory(block), 0600))
+	clientKey, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+
+	addr, hostKey := startSSHServer(t, clientKey)
+	knownHosts := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey)
+	require.NoError(t, os.WriteFile(knownHosts, []byte(line+"\n"), 0600))
+
+	host, port, _ := net.SplitHostPort(addr)
+	portNumber, _ := strconv.Atoi(port)
+	runtimeDir := filepath.Join(dir, "remote")
+	script := filepath.Join(dir, "kernel.py")
+	require.NoError(t, os.WriteFile(script, []byte(fakeRemoteKernel), 0644))
+
+	spec := kernelspec.KernelSpecJsonData{
+		Name: "remote",
+		Argv: []string{"python3", script, "-f", "{connection_file}"},
+		Env:  map[string]string{"GREETING": "hello world"},
+		Metadata: map[string]interface{}{
+			"kernel_provisioner": map[string]interface{}{
+				"provisioner_name": "ssh",
+				"config": map[string]interface{}{
+					"host":          host,
+					"port":          portNumber,
+					"identity_file": identityFile,
+					"known_hosts":   knownHosts,
+					"runtime_dir":   runtimeDir,
+				},
+			},
+		},
+	}
+	return spec, runtimeDir
+}
+
+func writeLocalConnectionFile(t *testing.T) (string, map[string]interface{}) {
+	info := map[string]interface{}{
+		"transport": "tcp", "ip": "127.0.0.1", "key": "secret-key", "signature_scheme": "hmac-sha256",
+	}
+	for _, port := range channelPorts {
+		info[port] = freePort(t)
+	}
+	data, err := json.Marshal(info)
+	require.NoError(t, err)
+	connFile := filepath.Join(t.TempDir(), "kernel.json")
+	require.NoError(t, os.WriteFile(connFile, data, 0600))
+	return connFile, info
+}
+
+func TestSSHProvisioner(t *testing.T) {
+	spec, runtimeDir := setUpSSH(t)
+	assert.Equal(t, "ssh", ProvisionerName(spec))
+	connFile, localInfo := writeLocalConnectionFile(t)
+
+	stderr := &syncBuffer{}
+	p := NewSSHProvisioner("remote-kernel", spec, &syncBuffer{}, stderr)
+	_, err := p.LaunchKernel(append([]string{}, spec.Argv...), nil, connFile)
+	require.NoError(t, err)
+
+	// the remote connection file keeps the key and is private
+	remoteFile := filepath.Join(runtimeDir, "kernel-remote-kernel.json")
+	info, err := os.Stat(remoteFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	remoteInfo, err := readConnectionFile(remoteFile)
+	require.NoError(t, err)
+	assert.Equal(t, "secret-key", remoteInfo["key"])
+
+	// the local shell port reaches the remote kernel
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(localInfo["shell_port"].(int))))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(reply))
+	conn.Close()
+
+	require.NoError(t, p.SignalKernel(os.Interrupt))
+	assert.Eventually(t, func() bool {
+		return bytes.Contains([]byte(stderr.String()), []byte("interrupted"))
+	}, 5*time.Second, 50*time.Millisecond)
+
+	require.NoError(t, p.ShutdownKernel())
+	select {
+	case <-p.Exited():
+	case <-time.After(5 * time.Second):
+		t.Fatal("remote kernel did not exit")
+	}
+	assert.NoFileExists(t, remoteFile)
+	_, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(localInfo["shell_port"].(int))))
+	assert.Error(t, err, "ports are no longer forwarded")
+}
+
+func TestSSHProvisionerChecksHostKey(t *testing.T) {
+	spec, _ := setUpSSH(t)
+	config := spec.Metadata.(map[string]interface{})["kernel_provisioner"].(map[string]interface{})["config"].(map[string]interface{})
+	otherHosts := filepath.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(otherHosts, nil, 0600))
+	config["known_hosts"] = otherHosts
+	connFile, _ := writeLocalConnectionFile(t)
+
+	p := NewSSHProvisioner("unknown-host", spec, nil, nil)
+	_, err := p.LaunchKernel(spec.Argv, nil, connFile)
+	var keyErr *knownhosts.KeyError
+	assert.ErrorAs(t, err, &keyErr)
+}
+
+func TestSSHProvisionerNeedsHost(t *testing.T) {
+	spec := kernelspec.KernelSpecJsonData{
+		Name:     "remote",
+		Metadata: map[string]interface{}{"kernel_provisioner": map[string]interface{}{"provisioner_name": "ssh"}},
+	}
+	p := NewSSHProvisioner("no-host", spec, nil, nil)
+	_, err := p.LaunchKernel(nil, nil, "")
+	assert.ErrorContains(t, err, "needs a host")
+}
+
+func TestRemoteKernelCommand(t *testing.T) {
+	cmd := remoteKernelCommand(
+		[]string{"python3", "-m", "ipykernel_launcher", "-f", "{connection_file}"},
+		"/tmp/kernel-1.json",
+		map[string]string{"PATH": "/opt/env/bin:${PATH}", "MSG": `it's "quoted"`},
+		[]string{"JPY_SESSION_NAME=/data/it's.ipynb", "MSG=session"},
+		"/data/my project",
+	)
+	assert.Equal(t, `cd '/data/my project' && echo $$ && exec env MSG="it's \"quoted\"" PATH="/opt/env/bin:${PATH}" JPY_SESSION_NAME='/data/it'\''s.ipynb' MSG='session' 'python3' '-m' 'ipykernel_launcher' '-f' '/tmp/kernel-1.json'`, cmd)
+}
+
+func TestRemoteKernelCommandQuoting(t *testing.T) {
+	tests := []struct {
+		name     string
+		specEnv  map[string]string
+		expected string
+	}{
+		{
+			name:     "variable reference",
+			specEnv:  map[string]string{"DATA": "${HOME}/data"},
+			expected: `DATA="${HOME}/data"`,
+		},
+		{
+			name:     "command substitution",
+			specEnv:  map[string]string{"X": "$(rm -rf ~)"},
+			expected: `X="\$(rm -rf ~)"`,
+		},
+		{
+			name:     "backticks",
+			specEnv:  map[string]string{"X": "`rm -rf ~`"},
+			expected: "X=\"\\`rm -rf ~\\`\"",
+		},
+		{
+			name:     "other expansions",
+			specEnv:  map[string]string{"X": `$HOME ${HOME:-x} $$ \${HOME}`},
+			expected: `X="\$HOME \${HOME:-x} \$\$ \\${HOME}"`,
+		},
+		{
+			name:     "invalid name",
+			specEnv:  map[string]string{"X;rm -rf ~;Y": "1"},
+			expected: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := remoteKernelCommand([]string{"python3"}, "", tt.specEnv, nil, "")
+			if tt.expected == "" {
+				assert.Equal(t, "echo $$ && exec 'python3'", cmd)
+				return
+			}
+			assert.Equal(t, "echo $$ && exec env "+tt.expected+" 'python3'", cmd)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package kernel
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// IOPubRateLimit bounds the iopub output sent to websocket clients, so that
+// a print in a tight loop does not freeze the browser. The rates are
+// averaged over Window, as in jupyter_server.
+type IOPubRateLimit struct {
+	// MsgRate is the maximum number of messages per second; 0 disables it.
+	MsgRate float64
+	// DataRate is the maximum number of bytes of iopub messages per second;
+	// 0 disables it.
+	DataRate float64
+	Window   time.Duration
+}
+
+var IOPubRateLimits = IOPubRateLimit{
+	MsgRate:  1000,
+	DataRate: 1000000,
+	Window:   3 * time.Second,
+}
+
+// iopubWindowEntry is a message counted in the rate limit window until it
+// expires.
+type iopubWindowEntry struct {
+	expires time.Time
+	bytes   int
+}
+
+// limitIOPub counts an iopub message of size bytes against the rate limits,
+// and tells whether it is forwarded to the client. When a limit is first
+// exceeded, it also returns the warning to send in place of the dropped
+// output. Messages flow again once the rate is down to 80% of the limit.
+func (kwsConn *KernelWebSocketConnection) limitIOPub(msg Message, size int, now time.Time) (bool, []byte) {
+	limits := IOPubRateLimits
+	if limits.Window <= 0 {
+		return true, nil
+	}
+
+	msgType := msg.Header.MsgType
+	if msgType == "status" {
+		if content, ok := msg.Content.(map[string]interface{}); ok && content["execution_state"] == ExecutionStateIdle {
+			// a new window for each execution, so that running all cells
+			// does not hit the limits
+			kwsConn.IOPubWindowByteQueue = nil
+			kwsConn.IOPubWindowMsgCount = 0
+			kwsConn.IOPubWindowByteCount = 0
+			kwsConn.IOPubMsgsExceeded = false
+			kwsConn.IOPubDataExceeded = false
+		}
+	}
+	if msgType == "status" || msgType == "comm_open" || msgType == "execute_input" {
+		return true, nil
+	}
+
+	// forget the messages out of the window
+	for len(kwsConn.IOPubWindowByteQueue) > 0 && !now.Before(kwsConn.IOPubWindowByteQueue[0].expires) {
+		kwsConn.IOPubWindowMsgCount--
+		kwsConn.IOPubWindowByteCount -= kwsConn.IOPubWindowByteQueue[0].bytes
+		kwsConn.IOPubWindowByteQueue = kwsConn.IOPubWindowByteQueue[1:]
+	}
+
+	kwsConn.IOPubWindowMsgCount++
+	kwsConn.IOPubWindowByteCount += size
+	kwsConn.IOPubWindowByteQueue = append(kwsConn.IOPubWindowByteQueue, iopubWindowEntry{expires: now.Add(limits.Window), bytes: size})
+
+	window := limits.Window.Seconds()
+	msgRate := float64(kwsConn.IOPubWindowMsgCount) / window
+	dataRate := float64(kwsConn.IOPubWindowByteCount) / window
+
+	var warning string
+	if limits.MsgRate > 0 && msgRate > limits.MsgRate {
+		if !kwsConn.IOPubMsgsExceeded {
+			kwsConn.IOPubMsgsExceeded = true
+			warning = fmt.Sprintf("IOPub message rate exceeded.\n"+
+				"Zasper will temporarily stop sending output\n"+
+				"to the client in order to avoid crashing it.\n"+
+				"To change this limit, set the -iopub-msg-rate-limit flag.\n\n"+
+				"Current values:\n"+
+				"iopub-msg-rate-limit=%g (msgs/sec)\n"+
+				"rate-limit-window=%g (secs)\n", limits.MsgRate, window)
+		}
+	} else if kwsConn.IOPubMsgsExceeded && msgRate < 0.8*limits.MsgRate {
+		kwsConn.IOPubMsgsExceeded = false
+		if !kwsConn.IOPubDataExceeded {
+			log.Warn().Msgf("iopub messages of kernel %s resumed", kwsConn.KernelId)
+		}
+	}
+	if limits.DataRate > 0 && dataRate > limits.DataRate {
+		if !kwsConn.IOPubDataExceeded {
+			kwsConn.IOPubDataExceeded = true
+			warning = fmt.Sprintf("IOPub data rate exceeded.\n"+
+				"Zasper will temporarily stop sending output\n"+
+				"to the client in order to avoid crashing it.\n"+
+				"To change this limit, set the -iopub-data-rate-limit flag.\n\n"+
+				"Current values:\n"+
+				"iopub-data-rate-limit=%g (bytes/sec)\n"+
+				"rate-limit-window=%g (secs)\n", limits.DataRate, window)
+		}
+	} else if kwsConn.IOPubDataExceeded && dataRate < 0.8*limits.DataRate {
+		kwsConn.IOPubDataExceeded = false
+		if !kwsConn.IOPubMsgsExceeded {
+			log.Warn().Msgf("iopub messages of kernel %s resumed", kwsConn.KernelId)
+		}
+	}
+
+	if !kwsConn.IOPubMsgsExceeded && !kwsConn.IOPubDataExceeded {
+		return true, nil
+	}
+
+	// the message is dropped, it does not count
+	kwsConn.IOPubWindowMsgCount--
+	kwsConn.IOPubWindowByteCount -= size
+	kwsConn.IOPubWindowByteQueue = kwsConn.IOPubWindowByteQueue[:len(kwsConn.IOPubWindowByteQueue)-1]
+
+	if warning == "" {
+		return false, nil
+	}
+	log.Warn().Msgf("kernel %s: %s", kwsConn.KernelId, warning)
+	stderr := kwsConn.Session.MessageFromString("stream")
+	stderr.ParentHeader = msg.ParentHeader
+	stderr.Channel = "iopub"
+	stderr.Content = map[string]interface{}{
+		"name": "stderr",
+		"text": warning,
+	}
+	data, err := json.Marshal(stderr)
+	if err != nil {
+		log.Error().Msgf("Error marshaling message: %v", err)
+		return false, nil
+	}
+	return false, data
+}
@@ -0,0 +1,123 @@
+package kernel
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitIOPub(t *testing.T) {
+	previous := IOPubRateLimits
+	defer func() { IOPubRateLimits = previous }()
+
+	execute := MessageHeader{MsgID: "execute-1", MsgType: "execute_request"}
+	message := func(msgType string, content map[string]interface{}) Message {
+		return Message{
+			Header:       MessageHeader{MsgType: msgType},
+			ParentHeader: execute,
+			Content:      content,
+			Channel:      "iopub",
+		}
+	}
+	stream := message("stream", map[string]interface{}{"name": "stdout", "text": "output\n"})
+	idle := message("status", map[string]interface{}{"execution_state": ExecutionStateIdle})
+
+	assertWarning := func(t *testing.T, data []byte, text string) {
+		t.Helper()
+		require.NotNil(t, data)
+		var warning Message
+		require.NoError(t, json.Unmarshal(data, &warning))
+		assert.Equal(t, "stream", warning.Header.MsgType)
+		assert.Equal(t, execute, warning.ParentHeader)
+		assert.Equal(t, "stderr", warning.Content.(map[string]interface{})["name"])
+		assert.Contains(t, warning.Content.(map[string]interface{})["text"], text)
+	}
+
+	t.Run("message rate", func(t *testing.T) {
+		IOPubRateLimits = IOPubRateLimit{MsgRate: 10, Window: time.Second}
+		kwsConn := &KernelWebSocketConnection{Session: getSession()}
+		now := time.Now()
+
+		for i := 0; i < 10; i++ {
+			forward, warning := kwsConn.limitIOPub(stream, 100, now)
+			assert.True(t, forward)
+			assert.Nil(t, warning)
+		}
+		forward, warning := kwsConn.limitIOPub(stream, 100, now)
+		assert.False(t, forward)
+		assertWarning(t, warning, "IOPub message rate exceeded")
+
+		// a single warning
+		forward, warning = kwsConn.limitIOPub(stream, 100, now)
+		assert.False(t, forward)
+		assert.Nil(t, warning)
+		// status messages are never dropped
+		forward, _ = kwsConn.limitIOPub(message("status", map[string]interface{}{"execution_state": ExecutionStateBusy}), 100, now)
+		assert.True(t, forward)
+
+		// resumed once the window passed
+		forward, warning = kwsConn.limitIOPub(stream, 100, now.Add(time.Second))
+		assert.True(t, forward)
+		assert.Nil(t, warning)
+	})
+
+	t.Run("data rate", func(t *testing.T) {
+		IOPubRateLimits = IOPubRateLimit{DataRate: 1000, Window: time.Second}
+		kwsConn := &KernelWebSocketConnection{Session: getSession()}
+		now := time.Now()
+
+		forward, _ := kwsConn.limitIOPub(stream, 600, now)
+		assert.True(t, forward)
+		forward, warning := kwsConn.limitIOPub(stream, 600, now)
+		assert.False(t, forward)
+		assertWarning(t, warning, "IOPub data rate exceeded")
+
+		// status messages do not count
+		forward, _ = kwsConn.limitIOPub(message("status", map[string]interface{}{"execution_state": ExecutionStateBusy}), 600, now)
+		assert.True(t, forward)
+	})
+
+	t.Run("large display data", func(t *testing.T) {
+		IOPubRateLimits = IOPubRateLimit{DataRate: 1000, Window: time.Second}
+		kwsConn := &KernelWebSocketConnection{Session: getSession()}
+		now := time.Now()
+
+		// a plot is counted as stream output is
+		plot := message("display_data", map[string]interface{}{"data": map[string]interface{}{"image/png": "iVBORw0KGgo="}})
+		forward, warning := kwsConn.limitIOPub(plot, 5000, now)
+		assert.False(t, forward)
+		assertWarning(t, warning, "IOPub data rate exceeded")
+
+		forward, _ = kwsConn.limitIOPub(stream, 100, now.Add(time.Second))
+		assert.True(t, forward)
+	})
+
+	t.Run("reset when idle", func(t *testing.T) {
+		IOPubRateLimits = IOPubRateLimit{MsgRate: 1, Window: time.Second}
+		kwsConn := &KernelWebSocketConnection{Session: getSession()}
+		now := time.Now()
+
+		kwsConn.limitIOPub(stream, 100, now)
+		forward, _ := kwsConn.limitIOPub(stream, 100, now)
+		assert.False(t, forward)
+
+		forward, _ = kwsConn.limitIOPub(idle, 100, now)
+		assert.True(t, forward)
+		forward, _ = kwsConn.limitIOPub(stream, 100, now)
+		assert.True(t, forward)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		IOPubRateLimits = IOPubRateLimit{Window: time.Second}
+		kwsConn := &KernelWebSocketConnection{Session: getSession()}
+		now := time.Now()
+
+		for i := 0; i < 100; i++ {
+			forward, _ := kwsConn.limitIOPub(stream, 1<<20, now)
+			assert.True(t, forward)
+		}
+	})
+}
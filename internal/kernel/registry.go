@@ -0,0 +1,79 @@
+package kernel
+
+import (
+	"sync"
+
+	"github.com/zasper-io/zasper/internal/events"
+)
+
+// KernelRegistry is a concurrency safe collection of kernel managers that
+// notifies subscribers of kernel lifecycle and status changes.
+type KernelRegistry struct {
+	mu      sync.RWMutex
+	kernels map[string]*KernelManager
+	events  *events.Hub[KernelEvent]
+}
+
+func NewKernelRegistry() *KernelRegistry {
+	return &KernelRegistry{
+		kernels: make(map[string]*KernelManager),
+		events:  events.NewHub[KernelEvent](),
+	}
+}
+
+func (r *KernelRegistry) Add(km *KernelManager) {
+	r.mu.Lock()
+	r.kernels[km.KernelId] = km
+	r.mu.Unlock()
+
+	event := km.statusEvent()
+	event.Type = KernelEventAdded
+	r.events.Publish(event)
+}
+
+func (r *KernelRegistry) Get(kernelId string) (*KernelManager, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	km, ok := r.kernels[kernelId]
+	return km, ok
+}
+
+// Remove deletes a kernel from the registry and returns it, if it was present.
+func (r *KernelRegistry) Remove(kernelId string) (*KernelManager, bool) {
+	r.mu.Lock()
+	km, ok := r.kernels[kernelId]
+	delete(r.kernels, kernelId)
+	r.mu.Unlock()
+
+	if ok {
+		event := km.statusEvent()
+		event.Type = KernelEventRemoved
+		r.events.Publish(event)
+	}
+	return km, ok
+}
+
+func (r *KernelRegistry) List() []*KernelManager {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	kernels := make([]*KernelManager, 0, len(r.kernels))
+	for _, km := range r.kernels {
+		kernels = append(kernels, km)
+	}
+	return kernels
+}
+
+func (r *KernelRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.kernels)
+}
+
+// Subscribe returns a channel of kernel events and a function releasing it.
+func (r *KernelRegistry) Subscribe() (<-chan KernelEvent, func()) {
+	return r.events.Subscribe()
+}
+
+func (r *KernelRegistry) publishStatus(km *KernelManager) {
+	r.events.Publish(km.statusEvent())
+}
@@ -0,0 +1,251 @@
+package kernel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-zeromq/zmq4"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zasper-io/zasper/internal/core"
+	"github.com/zasper-io/zasper/internal/kernel/provisioner"
+	"github.com/zasper-io/zasper/internal/kernelspec"
+)
+
+type fakeProvisioner struct {
+	mu       sync.Mutex
+	launched bool
+	launches int
+	shutdown bool
+	signals  []os.Signal
+	kw       map[string]interface{}
+	exited   chan struct{}
+	cancel   context.CancelFunc
+	sockets  []zmq4.Socket
+}
+
+func newFakeProvisioner() *fakeProvisioner {
+	return &fakeProvisioner{exited: make(chan struct{})}
+}
+
+// LaunchKernel answers every message on the shell, control and hb ports of
+// the connection file, which is enough for the kernel to be considered ready
+// and alive.
+func (p *fakeProvisioner) LaunchKernel(kernelCmd []string, kw map[string]interface{}, connFile string) (provisioner.KernelConnectionInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return provisioner.KernelConnectionInfo{}, p.launchLocked(kw, connFile, true)
+}
+
+// launchLocked listens on the ports of the connection file, the hb port only
+// if answerHeartbeat.
+func (p *fakeProvisioner) launchLocked(kw map[string]interface{}, connFile string, answerHeartbeat bool) error {
+	p.launches++
+	p.launched = true
+	p.kw = kw
+	if p.shutdown {
+		// launched again by a restart
+		p.shutdown = false
+		p.exited = make(chan struct{})
+	}
+
+	data, err := os.ReadFile(connFile)
+	if err != nil {
+		return err
+	}
+	var cinfo ConnectionFileData
+	if err := json.Unmarshal(data, &cinfo); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	conn := Connection{Transport: cinfo.Transport, IP: cinfo.IP}
+	ports := []int{cinfo.ShellPort, cinfo.ControlPort}
+	if answerHeartbeat {
+		ports = append(ports, cinfo.HbPort)
+	}
+	for _, port := range ports {
+		socket := zmq4.NewRouter(ctx)
+		if err := listenRetrying(socket, conn.makeURL("", port)); err != nil {
+			cancel()
+			return err
+		}
+		p.sockets = append(p.sockets, socket)
+		go func() {
+			defer socket.Close()
+			for {
+				msg, err := socket.Recv()
+				if err != nil {
+					return
+				}
+				socket.Send(msg)
+			}
+		}()
+	}
+	return nil
+}
+
+// listenRetrying gives the connections to the ports of a previous launch
+// the time to be closed.
+func listenRetrying(socket zmq4.Socket, endpoint string) error {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		err := socket.Listen(endpoint)
+		if err == nil || !errors.Is(err, syscall.EADDRINUSE) || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func (p *fakeProvisioner) SignalKernel(sig os.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.signals = append(p.signals, sig)
+	return nil
+}
+
+func (p *fakeProvisioner) ShutdownKernel() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.shutdown {
+		p.shutdown = true
+		if p.cancel != nil {
+			p.cancel()
+		}
+		// free the ports as an exited process does
+		for _, socket := range p.sockets {
+			socket.Close()
+		}
+		p.sockets = nil
+		close(p.exited)
+	}
+	return nil
+}
+
+func (p *fakeProvisioner) Exited() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.exited
+}
+
+// setUpFakeKernels installs a "fake" kernelspec and replaces the local
+// provisioner by the ones made by create, so that no kernel process is ever
+// started.
+func setUpFakeKernels[P provisioner.Provisioner](t *testing.T, create func() P) *sync.Map {
+	t.Helper()
+
+	jupyterDir := t.TempDir()
+	specDir := filepath.Join(jupyterDir, "kernels", "fake")
+	require.NoError(t, os.MkdirAll(specDir, 0755))
+	spec := `{"argv": ["fake-kernel", "-f", "{connection_file}"], "display_name": "Fake", "language": "fake"}`
+	require.NoError(t, os.WriteFile(filepath.Join(specDir, "kernel.json"), []byte(spec), 0644))
+
+	core.Zasper.JupyterRuntimeDir = filepath.Join(t.TempDir(), "runtime")
+	core.Zasper.JupyterPath = []string{jupyterDir}
+	kernelspec.RefreshSpecs()
+	ZasperActiveKernels = NewKernelRegistry()
+	ZasperPendingKernels = NewKernelRegistry()
+
+	provisioners := &sync.Map{}
+	previous := newProvisioner
+	newProvisioner = func(km *KernelManager) provisioner.Provisioner {
+		p := create()
+		provisioners.Store(km.KernelId, p)
+		return p
+	}
+	t.Cleanup(func() { newProvisioner = previous })
+	return provisioners
+}
+
+func waitForKernel(t *testing.T, kernelId string) error {
+	t.Helper()
+	km, ok := ZasperActiveKernels.Get(kernelId)
+	require.True(t, ok)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return km.WaitForReady(ctx)
+}
+
+func TestKernelRegistryConcurrentStartStop(t *testing.T) {
+	provisioners := setUpFakeKernels(t, newFakeProvisioner)
+
+	events, unsubscribe := ZasperActiveKernels.Subscribe()
+	defer unsubscribe()
+
+	const kernelCount = 10
+	var wg sync.WaitGroup
+	kernelIds := make(chan string, kernelCount)
+
+	for i := 0; i < kernelCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			kernelId, err := StartKernelManager("", "fake", nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+			// concurrent readers
+			listKernels()
+			assert.NoError(t, waitForKernel(t, kernelId))
+			NotifyConnect(kernelId)
+			kernelIds <- kernelId
+		}()
+	}
+	wg.Wait()
+	close(kernelIds)
+
+	assert.Equal(t, kernelCount, ZasperActiveKernels.Len())
+	assert.Equal(t, 0, ZasperPendingKernels.Len())
+
+	for kernelId := range kernelIds {
+		wg.Add(1)
+		go func(kernelId string) {
+			defer wg.Done()
+			model, err := getKernel(kernelId)
+			assert.NoError(t, err)
+			assert.Equal(t, ExecutionStateIdle, model.ExecutionState)
+			assert.Equal(t, 1, model.Connections)
+			assert.NoError(t, KillKernelById(kernelId))
+		}(kernelId)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 0, ZasperActiveKernels.Len())
+
+	provisioners.Range(func(key, value any) bool {
+		p := value.(*fakeProvisioner)
+		assert.True(t, p.launched, "kernel %s was not launched", key)
+		assert.True(t, p.shutdown, "kernel %s was not shut down", key)
+		return true
+	})
+
+	added, removed := 0, 0
+	for len(events) > 0 {
+		switch event := <-events; event.Type {
+		case KernelEventAdded:
+			added++
+		case KernelEventRemoved:
+			removed++
+		}
+	}
+	assert.Equal(t, kernelCount, added)
+	assert.Equal(t, kernelCount, removed)
+}
+
+func TestKillUnknownKernel(t *testing.T) {
+	setUpFakeKernels(t, newFakeProvisioner)
+
+	assert.ErrorIs(t, KillKernelById("does-not-exist"), ErrNoSuchKernel)
+	_, err := getKernel("does-not-exist")
+	assert.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package kernel
+
+import (
+	"encoding/json"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ReplayBufferSize is how many bytes of iopub messages are kept for a client
+// whose websocket dropped, to be replayed when it reconnects with the same
+// session id; 0 disables the buffering.
+var ReplayBufferSize = 10 << 20
+
+// replayBuffer holds the iopub messages of a kernel since its client with
+// session id sessionId disconnected, the oldest being dropped past
+// ReplayBufferSize.
+type replayBuffer struct {
+	sessionId string
+	messages  [][]byte
+	size      int
+	limit     int
+	dropped   int
+}
+
+// startBuffering keeps the iopub messages of the kernel from now on for the
+// client of session sessionId, which disconnected. A previous buffer for
+// another session is discarded, as jupyter_server does.
+func (km *KernelManager) startBuffering(sessionId string) {
+	if sessionId == "" || ReplayBufferSize <= 0 {
+		return
+	}
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	if km.ShuttingDown {
+		return
+	}
+	log.Debug().Msgf("buffering messages of kernel %s for session %s", km.KernelId, sessionId)
+	km.replay = &replayBuffer{sessionId: sessionId, limit: ReplayBufferSize}
+}
+
+// stopBuffering ends the buffering when a client of session sessionId
+// connects, and returns the messages it missed, oldest first. The messages
+// kept for another session are discarded.
+func (km *KernelManager) stopBuffering(sessionId string) [][]byte {
+	km.mu.Lock()
+	buffer := km.replay
+	km.replay = nil
+	km.mu.Unlock()
+
+	if buffer == nil {
+		return nil
+	}
+	if buffer.sessionId != sessionId {
+		log.Debug().Msgf("discarding %d buffered messages of kernel %s for session %s", len(buffer.messages), km.KernelId, buffer.sessionId)
+		return nil
+	}
+	if buffer.dropped > 0 {
+		log.Warn().Msgf("%d messages of kernel %s did not fit the replay buffer", buffer.dropped, km.KernelId)
+	}
+	return buffer.messages
+}
+
+// bufferMessage keeps an iopub message of the kernel while buffering.
+func (km *KernelManager) bufferMessage(msg Message) {
+	km.mu.Lock()
+	buffering := km.replay != nil
+	km.mu.Unlock()
+	if !buffering {
+		return
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Error().Msgf("Error marshaling message: %v", err)
+		return
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	buffer := km.replay
+	if buffer == nil {
+		return
+	}
+	buffer.messages = append(buffer.messages, data)
+	buffer.size += len(data)
+	for buffer.size > buffer.limit && len(buffer.messages) > 0 {
+		buffer.size -= len(buffer.messages[0])
+		buffer.messages[0] = nil
+		buffer.messages = buffer.messages[1:]
+		buffer.dropped++
+	}
+}
@@ -0,0 +1,173 @@
+package kernel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-zeromq/zmq4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayBuffer(t *testing.T) {
+	previous := ReplayBufferSize
+	defer func() { ReplayBufferSize = previous }()
+
+	stream := func(i int) Message {
+		return Message{
+			Header:  MessageHeader{MsgID: fmt.Sprintf("msg-%d", i), MsgType: "stream"},
+			Content: map[string]interface{}{"name": "stdout", "text": fmt.Sprintf("line %d\n", i)},
+			Channel: "iopub",
+		}
+	}
+	msgIds := func(messages [][]byte) []string {
+		ids := []string{}
+		for _, data := range messages {
+			var msg Message
+			require.NoError(t, json.Unmarshal(data, &msg))
+			ids = append(ids, msg.Header.MsgID)
+		}
+		return ids
+	}
+
+	t.Run("replayed to the same session", func(t *testing.T) {
+		ReplayBufferSize = 1 << 20
+		km := &KernelManager{KernelId: "kernel"}
+		km.bufferMessage(stream(0))
+		km.startBuffering("session-1")
+		km.bufferMessage(stream(1))
+		km.bufferMessage(stream(2))
+
+		assert.Equal(t, []string{"msg-1", "msg-2"}, msgIds(km.stopBuffering("session-1")))
+		km.bufferMessage(stream(3))
+		assert.Empty(t, km.stopBuffering("session-1"))
+	})
+
+	t.Run("discarded for another session", func(t *testing.T) {
+		ReplayBufferSize = 1 << 20
+		km := &KernelManager{KernelId: "kernel"}
+		km.startBuffering("session-1")
+		km.bufferMessage(stream(1))
+
+		assert.Empty(t, km.stopBuffering("session-2"))
+		assert.Empty(t, km.stopBuffering("session-1"))
+	})
+
+	t.Run("oldest dropped when full", func(t *testing.T) {
+		data, _ := json.Marshal(stream(0))
+		ReplayBufferSize = 3 * len(data)
+		km := &KernelManager{KernelId: "kernel"}
+		km.startBuffering("session-1")
+		for i := 0; i < 5; i++ {
+			km.bufferMessage(stream(i))
+		}
+
+		assert.Equal(t, []string{"msg-2", "msg-3", "msg-4"}, msgIds(km.stopBuffering("session-1")))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		ReplayBufferSize = 0
+		km := &KernelManager{KernelId: "kernel"}
+		km.startBuffering("session-1")
+		km.bufferMessage(stream(1))
+
+		assert.Empty(t, km.stopBuffering("session-1"))
+	})
+}
+
+func TestReplayToReconnectingClient(t *testing.T) {
+	previous := IOPubRateLimits
+	defer func() { IOPubRateLimits = previous }()
+
+	stream := func(id string) Message {
+		return Message{
+			Header:  MessageHeader{MsgID: id, MsgType: "stream"},
+			Content: map[string]interface{}{"name": "stdout", "text": id + "\n"},
+			Channel: "iopub",
+		}
+	}
+	buffered := func(t *testing.T, ids ...string) [][]byte {
+		messages := [][]byte{}
+		for _, id := range ids {
+			data, err := json.Marshal(stream(id))
+			require.NoError(t, err)
+			messages = append(messages, data)
+		}
+		return messages
+	}
+	// received returns the ids of the n next messages sent to the client
+	received := func(t *testing.T, kwsConn *KernelWebSocketConnection, n int) []string {
+		t.Helper()
+		ids := []string{}
+		for len(ids) < n {
+			select {
+			case data := <-kwsConn.Send:
+				var msg Message
+				require.NoError(t, json.Unmarshal(data, &msg))
+				if msg.Header.MsgID != "warmup" {
+					ids = append(ids, msg.Header.MsgID)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("received %v, expected %d messages", ids, n)
+			}
+		}
+		return ids
+	}
+
+	t.Run("not duplicated", func(t *testing.T) {
+		IOPubRateLimits = IOPubRateLimit{}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		session := getSession()
+
+		pub := zmq4.NewPub(ctx)
+		defer pub.Close()
+		require.NoError(t, pub.Listen("tcp://127.0.0.1:0"))
+		sub := zmq4.NewSub(ctx)
+		defer sub.Close()
+		require.NoError(t, sub.Dial("tcp://"+pub.Addr().String()))
+		require.NoError(t, sub.SetOption(zmq4.OptionSubscribe, ""))
+
+		// wait for the subscription to reach the publisher
+		subscribed := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case <-subscribed:
+					return
+				case <-time.After(10 * time.Millisecond):
+					session.SendStreamMsg(pub, stream("warmup"))
+				}
+			}
+		}()
+		_, err := sub.Recv()
+		close(subscribed)
+		require.NoError(t, err)
+
+		kwsConn := &KernelWebSocketConnection{Context: ctx, Session: session, Send: make(chan []byte, 10)}
+		kwsConn.pollChannel(sub, "iopub", buffered(t, "msg-1", "msg-2"))
+		assert.Equal(t, []string{"msg-1", "msg-2"}, received(t, kwsConn, 2))
+
+		// msg-2 was received by the channel before the buffering stopped
+		session.SendStreamMsg(pub, stream("msg-2"))
+		session.SendStreamMsg(pub, stream("msg-3"))
+		assert.Equal(t, []string{"msg-3"}, received(t, kwsConn, 1))
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		IOPubRateLimits = IOPubRateLimit{MsgRate: 1, Window: 2 * time.Second}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		kwsConn := &KernelWebSocketConnection{Context: ctx, Session: getSession(), Send: make(chan []byte, 10)}
+		kwsConn.pollChannel(zmq4.NewSub(ctx), "iopub", buffered(t, "msg-1", "msg-2", "msg-3", "msg-4"))
+		// the warning replaces the replayed output past the limit
+		ids := received(t, kwsConn, 3)
+		assert.Equal(t, []string{"msg-1", "msg-2"}, ids[:2])
+		assert.NotContains(t, ids, "msg-3")
+		assert.NotContains(t, ids, "msg-4")
+	})
+}
@@ -0,0 +1,109 @@
+package kernel
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zasper-io/zasper/internal/models"
+)
+
+var ErrResourcesUnavailable = errors.New("resource usage is not available")
+
+// cpuSampleInterval is how long the first measurement of a kernel waits to
+// compute its CPU usage. Later measurements use the previous one instead.
+const cpuSampleInterval = 200 * time.Millisecond
+
+// processSample is a snapshot of a kernel's process tree.
+type processSample struct {
+	time       time.Time
+	cpuSeconds float64
+	rss        uint64
+	openFiles  int
+	children   int
+}
+
+// resources measures the process tree of the kernel, starting from the pid
+// of its provisioner.
+func (km *KernelManager) resources() (models.KernelResources, error) {
+	p, ok := km.Provisioner.(interface{ ProcessId() int })
+	if !ok || p.ProcessId() == 0 {
+		return models.KernelResources{}, fmt.Errorf("%w: kernel %s has no local process", ErrResourcesUnavailable, km.KernelId)
+	}
+	pid := p.ProcessId()
+
+	sample, err := sampleProcessTree(pid)
+	if err != nil {
+		return models.KernelResources{}, err
+	}
+
+	km.mu.Lock()
+	previous := km.lastSample
+	km.mu.Unlock()
+	if previous.time.IsZero() || sample.cpuSeconds < previous.cpuSeconds {
+		previous = sample
+		time.Sleep(cpuSampleInterval)
+		if sample, err = sampleProcessTree(pid); err != nil {
+			return models.KernelResources{}, err
+		}
+	}
+
+	km.mu.Lock()
+	km.lastSample = sample
+	km.mu.Unlock()
+
+	cpuPercent := 0.0
+	if elapsed := sample.time.Sub(previous.time).Seconds(); elapsed > 0 {
+		cpuPercent = (sample.cpuSeconds - previous.cpuSeconds) / elapsed * 100
+	}
+	return models.KernelResources{
+		Pid:        pid,
+		RSS:        sample.rss,
+		CPUPercent: cpuPercent,
+		OpenFiles:  sample.openFiles,
+		Children:   sample.children,
+	}, nil
+}
+
+func getKernelResources(kernelId string) (models.KernelResources, error) {
+	km, ok := ZasperActiveKernels.Get(kernelId)
+	if !ok {
+		return models.KernelResources{}, fmt.Errorf("kernel %s not found", kernelId)
+	}
+	return km.resources()
+}
+
+// listKernelResources measures every kernel concurrently, skipping the ones
+// without a running process.
+func listKernelResources() map[string]models.KernelResources {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	usage := map[string]models.KernelResources{}
+	for _, km := range ZasperActiveKernels.List() {
+		wg.Add(1)
+		go func(km *KernelManager) {
+			defer wg.Done()
+			resources, err := km.resources()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			usage[km.KernelId] = resources
+			mu.Unlock()
+		}(km)
+	}
+	wg.Wait()
+	return usage
+}
+
+func totalResources(usage map[string]models.KernelResources) models.KernelResources {
+	total := models.KernelResources{}
+	for _, resources := range usage {
+		total.RSS += resources.RSS
+		total.CPUPercent += resources.CPUPercent
+		total.OpenFiles += resources.OpenFiles
+		total.Children += resources.Children
+	}
+	return total
+}
@@ -0,0 +1,111 @@
+package kernel
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of the cpu times in /proc/<pid>/stat.
+const clockTicks = 100
+
+type procStat struct {
+	ppid     int
+	cpuTicks uint64
+	rssPages uint64
+}
+
+// sampleProcessTree reads /proc for pid and all of its descendants.
+func sampleProcessTree(pid int) (processSample, error) {
+	root, err := readProcStat(pid)
+	if err != nil {
+		return processSample{}, fmt.Errorf("%w: process %d: %v", ErrResourcesUnavailable, pid, err)
+	}
+
+	stats := map[int]procStat{pid: root}
+	children := map[int][]int{}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return processSample{}, fmt.Errorf("%w: %v", ErrResourcesUnavailable, err)
+	}
+	for _, entry := range entries {
+		other, err := strconv.Atoi(entry.Name())
+		if err != nil || other == pid {
+			continue
+		}
+		stat, err := readProcStat(other)
+		if err != nil {
+			// the process exited while we were looking
+			continue
+		}
+		stats[other] = stat
+		children[stat.ppid] = append(children[stat.ppid], other)
+	}
+
+	sample := processSample{time: time.Now()}
+	pageSize := uint64(os.Getpagesize())
+	tree := []int{pid}
+	for i := 0; i < len(tree); i++ {
+		stat := stats[tree[i]]
+		sample.cpuSeconds += float64(stat.cpuTicks) / clockTicks
+		sample.rss += stat.rssPages * pageSize
+		sample.openFiles += countOpenFiles(tree[i])
+		tree = append(tree, children[tree[i]]...)
+	}
+	sample.children = len(tree) - 1
+	return sample, nil
+}
+
+func readProcStat(pid int) (procStat, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return procStat{}, err
+	}
+	return parseProcStat(string(data))
+}
+
+// parseProcStat parses the contents of /proc/<pid>/stat. The command name is
+// in parentheses and may itself contain spaces and parentheses.
+func parseProcStat(data string) (procStat, error) {
+	end := strings.LastIndexByte(data, ')')
+	if end < 0 {
+		return procStat{}, fmt.Errorf("malformed stat %q", data)
+	}
+	// fields[0] is the state, the third field of the file
+	fields := strings.Fields(data[end+1:])
+	if len(fields) < 22 {
+		return procStat{}, fmt.Errorf("malformed stat %q", data)
+	}
+
+	var stat procStat
+	var err error
+	if stat.ppid, err = strconv.Atoi(fields[1]); err != nil {
+		return procStat{}, err
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return procStat{}, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return procStat{}, err
+	}
+	stat.cpuTicks = utime + stime
+	rss, err := strconv.ParseInt(fields[21], 10, 64)
+	if err != nil {
+		return procStat{}, err
+	}
+	stat.rssPages = uint64(max(rss, 0))
+	return stat, nil
+}
+
+func countOpenFiles(pid int) int {
+	fds, err := os.ReadDir(filepath.Join("/proc", strconv.Itoa(pid), "fd"))
+	if err != nil {
+		return 0
+	}
+	return len(fds)
+}
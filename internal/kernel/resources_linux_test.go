@@ -0,0 +1,40 @@
+package kernel
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProcStat(t *testing.T) {
+	data := "4242 (python (kernel) x) S 4200 4242 4200 0 -1 4194304 1000 0 0 0 150 50 0 0 20 0 3 0 12345 100000000 2500 18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 2 0 0 0 0 0\n"
+
+	stat, err := parseProcStat(data)
+	require.NoError(t, err)
+	assert.Equal(t, 4200, stat.ppid)
+	assert.Equal(t, uint64(200), stat.cpuTicks)
+	assert.Equal(t, uint64(2500), stat.rssPages)
+
+	_, err = parseProcStat("4242 (truncated")
+	assert.Error(t, err)
+}
+
+func TestSampleProcessTree(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sleep 5 & sleep 5 & wait")
+	require.NoError(t, cmd.Start())
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	assert.Eventually(t, func() bool {
+		sample, err := sampleProcessTree(cmd.Process.Pid)
+		return err == nil && sample.children == 2
+	}, 5*time.Second, 50*time.Millisecond)
+
+	sample, err := sampleProcessTree(cmd.Process.Pid)
+	require.NoError(t, err)
+	assert.Greater(t, sample.rss, uint64(0))
+	assert.Greater(t, sample.openFiles, 0)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package kernel
+
+import "fmt"
+
+func sampleProcessTree(pid int) (processSample, error) {
+	return processSample{}, fmt.Errorf("%w on this platform", ErrResourcesUnavailable)
+}
@@ -0,0 +1,100 @@
+package kernel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/zasper-io/zasper/internal/kernel/provisioner"
+
+	"github.com/go-zeromq/zmq4"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// KernelStartupTimeout is how long a launched kernel has to answer its first
+// kernel_info_request before it is considered dead.
+var KernelStartupTimeout = 60 * time.Second
+
+var ErrNoSuchKernelspec = errors.New("no such kernelspec")
+
+var ErrPathOutsideProject = errors.New("path is outside the project directory")
+
+// waitForReady keeps sending kernel_info_requests on a transient shell
+// channel until the kernel replies, its process exits or the timeout expires.
+func (km *KernelManager) waitForReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-km.Provisioner.Exited():
+			return km.diagnoseExit()
+		default:
+		}
+		if km.isShuttingDown() {
+			return errors.New("kernel was shut down during startup")
+		}
+		if km.requestKernelInfo(time.Second) {
+			return nil
+		}
+	}
+	return fmt.Errorf("kernel did not respond within %s", timeout)
+}
+
+func (km *KernelManager) requestKernelInfo(timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	id := zmq4.SocketIdentity(fmt.Sprintf("startup-%s", uuid.New().String()))
+	shell := km.ConnectionInfo.ConnectShell(ctx, id)
+	defer shell.Close()
+
+	km.Session.SendStreamMsg(shell, km.Session.MessageFromString("kernel_info_request"))
+	if _, err := shell.Recv(); err != nil {
+		log.Debug().Msgf("no kernel_info_reply from kernel %s yet: %v", km.KernelId, err)
+		return false
+	}
+	return true
+}
+
+// diagnoseLaunchError turns a failure to start the kernel command into an
+// error a user can act upon.
+func diagnoseLaunchError(kernelCmd []string, err error) error {
+	if len(kernelCmd) > 0 && (errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist)) {
+		return fmt.Errorf("%s not found: check that it is installed and on the PATH", filepath.Base(kernelCmd[0]))
+	}
+	return fmt.Errorf("failed to launch kernel: %w", err)
+}
+
+// diagnoseExit explains why a kernel process exited before becoming ready.
+// Whether ipykernel is installed is only checked for local kernels, the
+// Python of remote and container kernels is not on this host.
+func (km *KernelManager) diagnoseExit() error {
+	if _, local := km.Provisioner.(*provisioner.LocalProvisioner); local && isIPythonKernel(km.kernelCmd) {
+		probe := exec.Command(km.kernelCmd[0], "-c", "import ipykernel")
+		if err := probe.Run(); err != nil {
+			return fmt.Errorf("ipykernel missing: run '%s -m pip install ipykernel'", km.kernelCmd[0])
+		}
+	}
+	if lines := km.Logs.Tail(1, "stderr"); len(lines) > 0 {
+		return fmt.Errorf("kernel process exited during startup: %s", lines[0].Text)
+	}
+	if p, ok := km.Provisioner.(interface{ ExitError() error }); ok && p.ExitError() != nil {
+		return fmt.Errorf("kernel process exited during startup: %v", p.ExitError())
+	}
+	return errors.New("kernel process exited during startup")
+}
+
+func isIPythonKernel(kernelCmd []string) bool {
+	if len(kernelCmd) < 3 || !strings.HasPrefix(filepath.Base(kernelCmd[0]), "python") {
+		return false
+	}
+	return slices.ContainsFunc(kernelCmd[1:], func(arg string) bool {
+		return strings.HasPrefix(arg, "ipykernel")
+	})
+}
@@ -0,0 +1,91 @@
+package kernel
+
+import (
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zasper-io/zasper/internal/kernel/provisioner"
+)
+
+func TestDiagnoseExit(t *testing.T) {
+	// a Python that cannot import ipykernel, as it does not exist
+	kernelCmd := []string{"python-does-not-exist", "-m", "ipykernel_launcher", "-f", "{connection_file}"}
+
+	tests := []struct {
+		name        string
+		provisioner provisioner.Provisioner
+		expected    string
+	}{
+		{
+			name:        "local kernel",
+			provisioner: &provisioner.LocalProvisioner{KernelId: "kernel"},
+			expected:    "ipykernel missing: run 'python-does-not-exist -m pip install ipykernel'",
+		},
+		{
+			name:        "remote kernel",
+			provisioner: &provisioner.SSHProvisioner{KernelId: "kernel"},
+			expected:    "kernel process exited during startup: bash: python: command not found",
+		},
+		{
+			name:        "container kernel",
+			provisioner: &provisioner.ContainerProvisioner{KernelId: "kernel"},
+			expected:    "kernel process exited during startup: bash: python: command not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			km := &KernelManager{
+				KernelId:    "kernel",
+				Provisioner: tt.provisioner,
+				Logs:        NewLogBuffer("kernel", 10),
+				kernelCmd:   kernelCmd,
+			}
+			fmt.Fprintln(km.Logs.Writer("stderr"), "bash: python: command not found")
+
+			assert.EqualError(t, km.diagnoseExit(), tt.expected)
+		})
+	}
+}
+
+// failingProvisioner cannot start its kernel.
+type failingProvisioner struct {
+	*fakeProvisioner
+	launchErr error
+}
+
+func (p *failingProvisioner) LaunchKernel(kernelCmd []string, kw map[string]interface{}, connFile string) (provisioner.KernelConnectionInfo, error) {
+	return nil, p.launchErr
+}
+
+func TestKernelStartupFailure(t *testing.T) {
+	setUpFakeKernels(t, func() *failingProvisioner {
+		return &failingProvisioner{
+			fakeProvisioner: newFakeProvisioner(),
+			launchErr:       &exec.Error{Name: "fake-kernel", Err: exec.ErrNotFound},
+		}
+	})
+
+	kernelId, err := StartKernelManager("", "fake", nil)
+	require.NoError(t, err)
+
+	err = waitForKernel(t, kernelId)
+	assert.EqualError(t, err, "fake-kernel not found: check that it is installed and on the PATH")
+
+	model, err := getKernel(kernelId)
+	assert.NoError(t, err)
+	assert.Equal(t, ExecutionStateDead, model.ExecutionState)
+	assert.Contains(t, model.Reason, "fake-kernel not found")
+	assert.Equal(t, 0, ZasperPendingKernels.Len())
+	assert.NoError(t, KillKernelById(kernelId))
+}
+
+func TestStartUnknownKernelspec(t *testing.T) {
+	setUpFakeKernels(t, newFakeProvisioner)
+
+	_, err := StartKernelManager("", "does-not-exist", nil)
+	assert.ErrorIs(t, err, ErrNoSuchKernelspec)
+	assert.Equal(t, 0, ZasperActiveKernels.Len())
+}
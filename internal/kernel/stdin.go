@@ -0,0 +1,93 @@
+package kernel
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// InputTimeout is how long an input() of a kernel waits for a client to
+// attach and answer it once the last client is gone, before it is cancelled
+// with a KeyboardInterrupt. Until then, a client that reconnects gets the
+// prompt again.
+var InputTimeout = 60 * time.Second
+
+// pendingInput is an input_request of the kernel waiting for its reply.
+type pendingInput struct {
+	// parentId is the id of the execute_request which called input()
+	parentId string
+	// data is the input_request as sent to clients
+	data   []byte
+	cancel *time.Timer
+}
+
+// trackInputRequest records an input_request, which the kernel keeps waiting
+// for until a client answers it or the execution is interrupted.
+func (km *KernelManager) trackInputRequest(msg Message, data []byte) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.clearPendingInputLocked()
+	km.pendingInput = &pendingInput{parentId: msg.ParentHeader.MsgID, data: data}
+	km.scheduleInputCancelLocked()
+}
+
+// inputAnswered forgets the pending input_request once a client replied.
+func (km *KernelManager) inputAnswered() {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.clearPendingInputLocked()
+}
+
+// pendingInputRequest returns the input_request the kernel waits a reply
+// for, to be replayed to a client which connects, or nil.
+func (km *KernelManager) pendingInputRequest() []byte {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	if km.pendingInput == nil {
+		return nil
+	}
+	return km.pendingInput.data
+}
+
+func (km *KernelManager) clearPendingInputLocked() {
+	if km.pendingInput != nil && km.pendingInput.cancel != nil {
+		km.pendingInput.cancel.Stop()
+	}
+	km.pendingInput = nil
+}
+
+// scheduleInputCancelLocked starts the countdown to cancel the pending input
+// while no client is connected, and stops it when one is.
+func (km *KernelManager) scheduleInputCancelLocked() {
+	p := km.pendingInput
+	if p == nil {
+		return
+	}
+	if km.Connections > 0 {
+		if p.cancel != nil {
+			p.cancel.Stop()
+			p.cancel = nil
+		}
+		return
+	}
+	if p.cancel == nil {
+		p.cancel = time.AfterFunc(InputTimeout, func() { km.cancelInput(p) })
+	}
+}
+
+// cancelInput interrupts the kernel blocked on an input_request nobody is
+// left to answer.
+func (km *KernelManager) cancelInput(p *pendingInput) {
+	km.mu.Lock()
+	if km.pendingInput != p || km.Connections > 0 || km.ShuttingDown {
+		km.mu.Unlock()
+		return
+	}
+	km.pendingInput = nil
+	km.mu.Unlock()
+
+	log.Info().Msgf("cancelling input request of kernel %s, no client is left to answer it", km.KernelId)
+	if err := km.Interrupt(); err != nil {
+		log.Error().Msgf("cannot interrupt kernel %s: %v", km.KernelId, err)
+	}
+}
@@ -0,0 +1,96 @@
+package kernel
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPendingInput(t *testing.T) {
+	previous := InputTimeout
+	InputTimeout = 50 * time.Millisecond
+	defer func() { InputTimeout = previous }()
+
+	provisioners := setUpFakeKernels(t, newFakeProvisioner)
+
+	inputRequest := Message{
+		Header:       MessageHeader{MsgID: "input-1", MsgType: "input_request"},
+		ParentHeader: MessageHeader{MsgID: "execute-1", MsgType: "execute_request"},
+		Content:      map[string]interface{}{"prompt": "Password: ", "password": true},
+	}
+	data := []byte(`{"msg_type": "input_request"}`)
+
+	tests := []struct {
+		name string
+		// after runs once input was requested by a connected client
+		after       func(kernelId string, km *KernelManager)
+		pending     bool
+		interrupted bool
+	}{
+		{
+			name:        "cancelled without client",
+			after:       func(kernelId string, km *KernelManager) { NotifyDisconnect(kernelId) },
+			interrupted: true,
+		},
+		{
+			name: "kept for a reconnecting client",
+			after: func(kernelId string, km *KernelManager) {
+				NotifyDisconnect(kernelId)
+				NotifyConnect(kernelId)
+			},
+			pending: true,
+		},
+		{
+			name: "answered",
+			after: func(kernelId string, km *KernelManager) {
+				km.inputAnswered()
+				NotifyDisconnect(kernelId)
+			},
+		},
+		{
+			name: "execution over",
+			after: func(kernelId string, km *KernelManager) {
+				recordActivity(kernelId, Message{
+					Header:       MessageHeader{MsgType: "status"},
+					ParentHeader: inputRequest.ParentHeader,
+					Content:      map[string]interface{}{"execution_state": ExecutionStateIdle},
+				})
+				NotifyDisconnect(kernelId)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kernelId, err := StartKernelManager("", "fake", nil)
+			require.NoError(t, err)
+			defer KillKernelById(kernelId)
+			require.NoError(t, waitForKernel(t, kernelId))
+			km, _ := GetKernelManager(kernelId)
+
+			NotifyConnect(kernelId)
+			km.trackInputRequest(inputRequest, data)
+			assert.Equal(t, data, km.pendingInputRequest())
+
+			tt.after(kernelId, km)
+			time.Sleep(4 * InputTimeout)
+
+			if tt.pending {
+				assert.Equal(t, data, km.pendingInputRequest())
+			} else {
+				assert.Nil(t, km.pendingInputRequest())
+			}
+			var signals []os.Signal
+			if tt.interrupted {
+				signals = []os.Signal{syscall.SIGINT}
+			}
+			p, _ := provisioners.Load(kernelId)
+			p.(*fakeProvisioner).mu.Lock()
+			defer p.(*fakeProvisioner).mu.Unlock()
+			assert.Equal(t, signals, p.(*fakeProvisioner).signals)
+		})
+	}
+}
@@ -3,12 +3,15 @@ package kernel
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	mrand "math/rand/v2"
 	"net"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
+	"syscall"
 
 	"github.com/rs/zerolog/log"
 )
@@ -47,78 +50,111 @@ func newIDBytes() []byte {
 **********************************************************************
 *********************************************************************/
 
+// KernelPortRange is the range, end excluded, the ports of kernels are taken
+// from.
+var KernelPortRange = [2]int{5000, 6000}
+
+// reservedPorts maps the ports handed out to kernels to their kernel id, so
+// that kernels starting in parallel never get the same port. A port stays
+// reserved until its kernel is shut down.
 var (
-	currentlyUsedPorts []int
-	portMutex          sync.Mutex
+	reservedPorts = map[int]string{}
+	portMutex     sync.Mutex
 )
 
-func isPortAvailable(port int) bool {
-	// Test localhost binding (what Python ZeroMQ uses)
+// portReservation holds the ports reserved for a kernel bound until the
+// kernel is about to be launched, so that no other process can take them in
+// between.
+type portReservation struct {
+	Ports     []int
+	listeners []net.Listener
+}
+
+// unbind frees the ports for the kernel to bind them.
+func (r *portReservation) unbind() {
+	if r == nil {
+		return
+	}
+	for _, listener := range r.listeners {
+		listener.Close()
+	}
+	r.listeners = nil
+}
+
+// bindPort binds port on both 127.0.0.1, as kernels do, and all interfaces,
+// and returns the all-interfaces listener that keeps it bound.
+func bindPort(port int) (net.Listener, bool) {
 	localListener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
 	if err != nil {
 		log.Debug().Msgf("Port %d: localhost binding failed - %v", port, err)
-		return false
+		return nil, false
 	}
-	defer localListener.Close()
+	// Linux refuses the all-interfaces bind while the localhost one is held
+	localListener.Close()
 
-	// Test all-interfaces binding (for completeness)
 	allListener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		log.Debug().Msgf("Port %d: all-interfaces binding failed - %v", port, err)
-		return false
+		return nil, false
 	}
-	defer allListener.Close()
-
-	log.Debug().Msgf("Port %d: available on both 127.0.0.1 and 0.0.0.0", port)
-	return true
+	return allListener, true
 }
 
-func findAvailablePort() (int, error) {
+// reservePorts reserves n free ports for kernelId.
+func reservePorts(kernelId string, n int) (*portReservation, error) {
 	portMutex.Lock()
 	defer portMutex.Unlock()
 
-	maxAttempts := 100
-	for attempt := 0; attempt < maxAttempts; attempt++ {
-		port := mrand.IntN(1000) + 5000
-
-		// Skip if we've already allocated this port
-		if portExists(port) {
-			log.Debug().Msgf("Port %d: already in our tracking list", port)
+	reservation := &portReservation{}
+	maxAttempts := 100 * n
+	for attempt := 0; attempt < maxAttempts && len(reservation.Ports) < n; attempt++ {
+		port := KernelPortRange[0] + mrand.IntN(KernelPortRange[1]-KernelPortRange[0])
+		if _, ok := reservedPorts[port]; ok {
+			log.Debug().Msgf("Port %d: already reserved", port)
 			continue
 		}
-
-		// Check if port is available on both interfaces
-		if !isPortAvailable(port) {
+		listener, ok := bindPort(port)
+		if !ok {
 			continue
 		}
-
-		// Port is free - claim it
-		currentlyUsedPorts = append(currentlyUsedPorts, port)
-		log.Debug().Msgf("Successfully allocated port %d", port)
-		return port, nil
+		reservedPorts[port] = kernelId
+		reservation.Ports = append(reservation.Ports, port)
+		reservation.listeners = append(reservation.listeners, listener)
 	}
-
-	return 0, fmt.Errorf("could not find available port after %d attempts", maxAttempts)
-}
-
-func portExists(portNum int) bool {
-	for _, port := range currentlyUsedPorts {
-		if port == portNum {
-			return true
+	if len(reservation.Ports) < n {
+		reservation.unbind()
+		for _, port := range reservation.Ports {
+			delete(reservedPorts, port)
 		}
+		return nil, fmt.Errorf("could not find %d available ports after %d attempts", n, maxAttempts)
 	}
-	return false
+	log.Debug().Msgf("Reserved ports %v for kernel %s", reservation.Ports, kernelId)
+	return reservation, nil
 }
 
-func releasePort(port int) {
+// releasePorts releases the ports reserved for kernelId.
+func releasePorts(kernelId string) {
 	portMutex.Lock()
 	defer portMutex.Unlock()
 
-	for i, p := range currentlyUsedPorts {
-		if p == port {
-			currentlyUsedPorts = append(currentlyUsedPorts[:i], currentlyUsedPorts[i+1:]...)
-			log.Debug().Msgf("Released port %d", port)
-			return
+	for port, id := range reservedPorts {
+		if id == kernelId {
+			delete(reservedPorts, port)
 		}
 	}
+	log.Debug().Msgf("Released the ports of kernel %s", kernelId)
+}
+
+// isAddrInUse reports whether a kernel failed because one of its ports was
+// taken, either when it was launched or by the message it exited with.
+func isAddrInUse(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	// zmq and the engines of containers report it in their own words
+	return strings.Contains(msg, "address already in use") || strings.Contains(msg, "port is already allocated")
 }
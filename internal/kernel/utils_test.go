@@ -0,0 +1,96 @@
+package kernel
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zasper-io/zasper/internal/kernel/provisioner"
+)
+
+func TestReservePorts(t *testing.T) {
+	const kernelCount = 20
+	var wg sync.WaitGroup
+	reservations := make([]*portReservation, kernelCount)
+	for i := range reservations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reservation, err := reservePorts(fmt.Sprintf("kernel-%d", i), 5)
+			assert.NoError(t, err)
+			reservations[i] = reservation
+		}()
+	}
+	wg.Wait()
+
+	seen := map[int]bool{}
+	for i, reservation := range reservations {
+		require.NotNil(t, reservation)
+		require.Len(t, reservation.Ports, 5)
+		for _, port := range reservation.Ports {
+			assert.False(t, seen[port], "port %d reserved twice", port)
+			seen[port] = true
+		}
+		// the ports stay bound until the kernel is launched
+		_, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", reservation.Ports[0]))
+		assert.Error(t, err)
+		reservation.unbind()
+		listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", reservation.Ports[0]))
+		require.NoError(t, err)
+		listener.Close()
+
+		releasePorts(fmt.Sprintf("kernel-%d", i))
+	}
+
+	portMutex.Lock()
+	defer portMutex.Unlock()
+	for port := range seen {
+		assert.NotContains(t, reservedPorts, port)
+	}
+}
+
+func TestIsAddrInUse(t *testing.T) {
+	assert.True(t, isAddrInUse(fmt.Errorf("failed to launch kernel: %w", syscall.EADDRINUSE)))
+	assert.True(t, isAddrInUse(fmt.Errorf("kernel process exited during startup: zmq.error.ZMQError: Address already in use (addr='tcp://127.0.0.1:5123')")))
+	assert.True(t, isAddrInUse(fmt.Errorf("cannot start container: 500 Internal Server Error: Bind for 127.0.0.1:5123 failed: port is already allocated")))
+	assert.False(t, isAddrInUse(fmt.Errorf("kernel process exited during startup")))
+	assert.False(t, isAddrInUse(nil))
+}
+
+// busyPortProvisioner fails its first launches on a taken port.
+type busyPortProvisioner struct {
+	*fakeProvisioner
+	addrInUse int
+}
+
+func (p *busyPortProvisioner) LaunchKernel(kernelCmd []string, kw map[string]interface{}, connFile string) (provisioner.KernelConnectionInfo, error) {
+	p.mu.Lock()
+	if p.addrInUse > 0 {
+		p.addrInUse--
+		p.launches++
+		p.mu.Unlock()
+		return nil, fmt.Errorf("listen tcp: %w", syscall.EADDRINUSE)
+	}
+	p.mu.Unlock()
+	return p.fakeProvisioner.LaunchKernel(kernelCmd, kw, connFile)
+}
+
+func TestKernelRelaunchedWhenPortTaken(t *testing.T) {
+	provisioners := setUpFakeKernels(t, func() *busyPortProvisioner {
+		return &busyPortProvisioner{fakeProvisioner: newFakeProvisioner(), addrInUse: 1}
+	})
+
+	kernelId, err := StartKernelManager("", "fake", nil)
+	require.NoError(t, err)
+	defer KillKernelById(kernelId)
+	require.NoError(t, waitForKernel(t, kernelId))
+
+	p, _ := provisioners.Load(kernelId)
+	p.(*busyPortProvisioner).mu.Lock()
+	defer p.(*busyPortProvisioner).mu.Unlock()
+	assert.Equal(t, 2, p.(*busyPortProvisioner).launches)
+}
@@ -0,0 +1,91 @@
+package kernelspec
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultKernelName is the kernelspec configured on the server for notebooks
+// that do not ask for one.
+var DefaultKernelName string
+
+// fallbackKernelName is used when nothing else matches.
+const fallbackKernelName = "python3"
+
+// NotebookKernel is the kernel recorded in the metadata of a notebook.
+type NotebookKernel struct {
+	Name     string
+	Language string
+}
+
+// DefaultKernelspec resolves the kernelspec to start when the client does not
+// name one: the server configuration, then the kernelspec of the notebook,
+// then a kernelspec for the language of the notebook, then python3.
+func DefaultKernelspec(nb NotebookKernel) string {
+	specs := GetAllSpecs()
+	for _, name := range []string{DefaultKernelName, nb.Name} {
+		if _, ok := specs[name]; ok && name != "" {
+			return name
+		}
+	}
+	if name, ok := matchLanguage(specs, nb.Language, nb.Name); ok {
+		return name
+	}
+	if _, ok := specs[fallbackKernelName]; ok {
+		return fallbackKernelName
+	}
+	if name, ok := matchLanguage(specs, "python", fallbackKernelName); ok {
+		return name
+	}
+	return fallbackKernelName
+}
+
+// ResolveKernelName returns the kernelspec to start for the requested name.
+// When it does not exist, as for notebooks written on another machine, the
+// closest kernelspec for the language of the notebook is used instead.
+func ResolveKernelName(requested string, nb NotebookKernel) (string, bool) {
+	specs := GetAllSpecs()
+	if _, ok := specs[requested]; ok {
+		return requested, true
+	}
+	name, ok := matchLanguage(specs, nb.Language, requested)
+	if ok {
+		log.Info().Msgf("kernelspec %s not found, using %s for %s", requested, name, nb.Language)
+	}
+	return name, ok
+}
+
+// matchLanguage finds a kernelspec for language, preferring the one whose
+// name shares the longest prefix with preferred, e.g. python3 for python3.9.
+func matchLanguage(specs map[string]KspecData, language string, preferred string) (string, bool) {
+	if language == "" {
+		return "", false
+	}
+	candidates := []string{}
+	for name, kspec := range specs {
+		if strings.EqualFold(kspec.Spec.Language, language) {
+			candidates = append(candidates, name)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		pi, pj := commonPrefixLength(candidates[i], preferred), commonPrefixLength(candidates[j], preferred)
+		if pi != pj {
+			return pi > pj
+		}
+		return candidates[i] < candidates[j]
+	})
+	return candidates[0], true
+}
+
+func commonPrefixLength(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
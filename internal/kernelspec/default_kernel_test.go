@@ -0,0 +1,67 @@
+package kernelspec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeLanguageSpec(t *testing.T, dir, name, language string) {
+	t.Helper()
+	specDir := filepath.Join(dir, "kernels", name)
+	require.NoError(t, os.MkdirAll(specDir, 0755))
+	spec := `{"argv": ["kernel", "{connection_file}"], "display_name": "` + name + `", "language": "` + language + `"}`
+	require.NoError(t, os.WriteFile(filepath.Join(specDir, "kernel.json"), []byte(spec), 0644))
+}
+
+func TestDefaultKernelspec(t *testing.T) {
+	_, systemDir := setUpKernelspecDirs(t)
+	writeLanguageSpec(t, systemDir, "python3", "python")
+	writeLanguageSpec(t, systemDir, "ir", "R")
+	writeLanguageSpec(t, systemDir, "julia-1.10", "julia")
+	writeLanguageSpec(t, systemDir, "julia-1.11", "julia")
+	RefreshSpecs()
+
+	tests := []struct {
+		name       string
+		configured string
+		nb         NotebookKernel
+		expected   string
+	}{
+		{"nothing known", "", NotebookKernel{}, "python3"},
+		{"server config", "ir", NotebookKernel{Name: "julia-1.11"}, "ir"},
+		{"missing server config", "octave", NotebookKernel{Name: "julia-1.11"}, "julia-1.11"},
+		{"notebook kernelspec", "", NotebookKernel{Name: "ir", Language: "R"}, "ir"},
+		{"notebook language", "", NotebookKernel{Name: "julia-1.9", Language: "julia"}, "julia-1.10"},
+		{"case insensitive language", "", NotebookKernel{Language: "r"}, "ir"},
+		{"unknown language", "", NotebookKernel{Name: "scala", Language: "scala"}, "python3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DefaultKernelName = tt.configured
+			defer func() { DefaultKernelName = "" }()
+			assert.Equal(t, tt.expected, DefaultKernelspec(tt.nb))
+		})
+	}
+}
+
+func TestResolveKernelName(t *testing.T) {
+	_, systemDir := setUpKernelspecDirs(t)
+	writeLanguageSpec(t, systemDir, "python3", "python")
+	writeLanguageSpec(t, systemDir, "venv-.venv", "python")
+	RefreshSpecs()
+
+	name, ok := ResolveKernelName("venv-.venv", NotebookKernel{Language: "python"})
+	assert.True(t, ok)
+	assert.Equal(t, "venv-.venv", name)
+
+	name, ok = ResolveKernelName("python3.9", NotebookKernel{Name: "python3.9", Language: "python"})
+	assert.True(t, ok)
+	assert.Equal(t, "python3", name)
+
+	_, ok = ResolveKernelName("ir", NotebookKernel{Name: "ir", Language: "R"})
+	assert.False(t, ok)
+}
@@ -0,0 +1,220 @@
+package kernelspec
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/zasper-io/zasper/internal/core"
+
+	"github.com/rs/zerolog/log"
+)
+
+// PythonEnvironment is a Python installation found on the machine, which can
+// run a kernel without a kernelspec being installed for it.
+type PythonEnvironment struct {
+	// Kind is one of "venv", "conda", "pyenv" or "uv"
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+	Python string `json:"python"`
+}
+
+// FindPythonEnvironments scans the project virtualenvs, conda environments,
+// pyenv versions and uv managed Pythons, and returns those that have
+// ipykernel installed.
+func FindPythonEnvironments() []PythonEnvironment {
+	candidates := []PythonEnvironment{}
+	add := func(kind, name, prefix string) {
+		candidates = append(candidates, PythonEnvironment{Kind: kind, Name: name, Prefix: prefix})
+	}
+
+	for _, dir := range []string{".venv", "venv"} {
+		add("venv", dir, filepath.Join(core.Zasper.HomeDir, dir))
+	}
+	if uvEnv := os.Getenv("UV_PROJECT_ENVIRONMENT"); uvEnv != "" {
+		if !filepath.IsAbs(uvEnv) {
+			uvEnv = filepath.Join(core.Zasper.HomeDir, uvEnv)
+		}
+		add("uv", filepath.Base(uvEnv), uvEnv)
+	}
+	for _, prefix := range condaEnvironments() {
+		add("conda", condaEnvName(prefix), prefix)
+	}
+	for _, prefix := range subdirs(pyenvRoot(), "versions") {
+		add("pyenv", filepath.Base(prefix), prefix)
+	}
+	for _, prefix := range subdirs(uvPythonDir()) {
+		add("uv", filepath.Base(prefix), prefix)
+	}
+
+	seen := map[string]bool{}
+	environments := []PythonEnvironment{}
+	for _, env := range candidates {
+		prefix, err := filepath.EvalSymlinks(env.Prefix)
+		if err != nil || seen[prefix] {
+			continue
+		}
+		seen[prefix] = true
+		env.Python = pythonExecutable(env.Prefix)
+		if env.Python == "" || !hasIPyKernel(env.Prefix) {
+			continue
+		}
+		environments = append(environments, env)
+	}
+	log.Debug().Msgf("found %d python environments with ipykernel", len(environments))
+	return environments
+}
+
+// environmentSpecs returns a synthetic kernelspec for every environment.
+func environmentSpecs() map[string]KernelSpecJsonData {
+	specs := map[string]KernelSpecJsonData{}
+	for _, env := range FindPythonEnvironments() {
+		specs[env.KernelName()] = env.Kernelspec()
+	}
+	return specs
+}
+
+var invalidKernelNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// KernelName is the name of the synthetic kernelspec, e.g. "conda-myenv" or
+// "venv-.venv".
+func (env PythonEnvironment) KernelName() string {
+	name := invalidKernelNameChars.ReplaceAllString(env.Name, "-")
+	return strings.ToLower(env.Kind + "-" + name)
+}
+
+func (env PythonEnvironment) Kernelspec() KernelSpecJsonData {
+	binDir := filepath.Dir(env.Python)
+	vars := map[string]string{
+		"PATH": binDir + string(os.PathListSeparator) + "${PATH}",
+	}
+	switch env.Kind {
+	case "venv":
+		vars["VIRTUAL_ENV"] = env.Prefix
+	case "conda":
+		vars["CONDA_PREFIX"] = env.Prefix
+		vars["CONDA_DEFAULT_ENV"] = env.Name
+	}
+
+	displayName := "Python (" + env.Name + ")"
+	if env.Kind != "venv" {
+		displayName = "Python (" + env.Kind + ": " + env.Name + ")"
+	}
+	return KernelSpecJsonData{
+		Argv:        []string{env.Python, "-m", "ipykernel_launcher", "-f", "{connection_file}"},
+		DisplayName: displayName,
+		Language:    "python",
+		Name:        env.KernelName(),
+		Env:         vars,
+		Metadata: map[string]interface{}{
+			"debugger":    true,
+			"environment": env,
+		},
+	}
+}
+
+func pythonExecutable(prefix string) string {
+	candidates := []string{"bin/python", "bin/python3"}
+	if runtime.GOOS == "windows" {
+		candidates = []string{"python.exe", "Scripts/python.exe"}
+	}
+	for _, candidate := range candidates {
+		path := filepath.Join(prefix, filepath.FromSlash(candidate))
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// hasIPyKernel looks for ipykernel in the site-packages of the environment,
+// which is much faster than importing it.
+func hasIPyKernel(prefix string) bool {
+	patterns := []string{"lib/python3*/site-packages/ipykernel", "lib64/python3*/site-packages/ipykernel"}
+	if runtime.GOOS == "windows" {
+		patterns = []string{"Lib/site-packages/ipykernel"}
+	}
+	for _, pattern := range patterns {
+		if matches, _ := filepath.Glob(filepath.Join(prefix, filepath.FromSlash(pattern))); len(matches) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// condaEnvironments lists the environments registered in
+// ~/.conda/environments.txt, the active one and the usual install locations.
+func condaEnvironments() []string {
+	home, _ := os.UserHomeDir()
+	prefixes := []string{}
+	if prefix := os.Getenv("CONDA_PREFIX"); prefix != "" {
+		prefixes = append(prefixes, prefix)
+	}
+
+	if file, err := os.Open(filepath.Join(home, ".conda", "environments.txt")); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				prefixes = append(prefixes, line)
+			}
+		}
+		file.Close()
+	}
+
+	for _, base := range []string{"miniconda3", "anaconda3", "miniforge3", "mambaforge"} {
+		root := filepath.Join(home, base)
+		prefixes = append(prefixes, root)
+		prefixes = append(prefixes, subdirs(root, "envs")...)
+	}
+	return prefixes
+}
+
+// condaEnvName is "base" for a conda installation, and the directory name
+// for the environments in its envs directory.
+func condaEnvName(prefix string) string {
+	if filepath.Base(filepath.Dir(prefix)) == "envs" {
+		return filepath.Base(prefix)
+	}
+	return "base"
+}
+
+func pyenvRoot() string {
+	if root := os.Getenv("PYENV_ROOT"); root != "" {
+		return root
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".pyenv")
+}
+
+func uvPythonDir() string {
+	if dir := os.Getenv("UV_PYTHON_INSTALL_DIR"); dir != "" {
+		return dir
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "uv", "python")
+	}
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		return filepath.Join(dataHome, "uv", "python")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".local", "share", "uv", "python")
+}
+
+func subdirs(elem ...string) []string {
+	dir := filepath.Join(elem...)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	dirs := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return dirs
+}
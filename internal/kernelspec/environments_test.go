@@ -0,0 +1,65 @@
+package kernelspec
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zasper-io/zasper/internal/core"
+)
+
+// makeEnvironment creates a fake Python installation at prefix.
+func makeEnvironment(t *testing.T, prefix string, withIPyKernel bool) string {
+	t.Helper()
+	python := filepath.Join(prefix, "bin", "python")
+	sitePackages := filepath.Join(prefix, "lib", "python3.12", "site-packages")
+	if runtime.GOOS == "windows" {
+		python = filepath.Join(prefix, "python.exe")
+		sitePackages = filepath.Join(prefix, "Lib", "site-packages")
+	}
+	require.NoError(t, os.MkdirAll(filepath.Dir(python), 0755))
+	require.NoError(t, os.WriteFile(python, nil, 0755))
+	require.NoError(t, os.MkdirAll(sitePackages, 0755))
+	if withIPyKernel {
+		require.NoError(t, os.MkdirAll(filepath.Join(sitePackages, "ipykernel"), 0755))
+	}
+	return python
+}
+
+func TestFindPythonEnvironments(t *testing.T) {
+	home := t.TempDir()
+	project := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("PYENV_ROOT", filepath.Join(home, "pyenv"))
+	t.Setenv("UV_PYTHON_INSTALL_DIR", filepath.Join(home, "uv"))
+	t.Setenv("CONDA_PREFIX", "")
+	t.Setenv("UV_PROJECT_ENVIRONMENT", "")
+	core.Zasper.HomeDir = project
+	core.Zasper.JupyterPath = []string{}
+	kernelspecCache.invalidate()
+
+	venvPython := makeEnvironment(t, filepath.Join(project, ".venv"), true)
+	makeEnvironment(t, filepath.Join(project, "venv"), false)
+	makeEnvironment(t, filepath.Join(home, "miniconda3"), true)
+	makeEnvironment(t, filepath.Join(home, "miniconda3", "envs", "data science"), true)
+	makeEnvironment(t, filepath.Join(home, "pyenv", "versions", "3.12.1"), true)
+	makeEnvironment(t, filepath.Join(home, "uv", "cpython-3.13.0-linux-x86_64-gnu"), false)
+
+	names := []string{}
+	for _, env := range FindPythonEnvironments() {
+		names = append(names, env.KernelName())
+	}
+	assert.ElementsMatch(t, []string{"venv-.venv", "conda-base", "conda-data-science", "pyenv-3.12.1"}, names)
+
+	specs := GetAllSpecs()
+	require.Contains(t, specs, "venv-.venv")
+	assert.Equal(t, "Python (.venv)", specs["venv-.venv"].Spec.DisplayName)
+
+	spec := GetKernelSpec("venv-.venv")
+	assert.Equal(t, []string{venvPython, "-m", "ipykernel_launcher", "-f", "{connection_file}"}, spec.Argv)
+	assert.Equal(t, filepath.Join(project, ".venv"), spec.Env["VIRTUAL_ENV"])
+}
@@ -2,9 +2,13 @@ package kernelspec
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
@@ -57,13 +61,21 @@ func SingleKernelspecAPIHandler(w http.ResponseWriter, req *http.Request) {
 }
 
 func KernelspecAPIHandler(w http.ResponseWriter, req *http.Request) {
+	writeKernelspecs(w, GetAllSpecs())
+}
+
+// KernelspecRefreshAPIHandler rescans the kernel directories and Python
+// environments, for changes the filesystem watches cannot see.
+func KernelspecRefreshAPIHandler(w http.ResponseWriter, req *http.Request) {
+	writeKernelspecs(w, RefreshSpecs())
+}
+
+func writeKernelspecs(w http.ResponseWriter, available_kernelspec map[string]KspecData) {
 	response := KernelspecResponse{
-		Default:    "python3",
+		Default:    DefaultKernelspec(NotebookKernel{}),
 		Kernespecs: make(map[string]KernelspecModel),
 	}
 
-	available_kernelspec := GetAllSpecs()
-
 	for kernelName, kernelInfo := range available_kernelspec {
 		response.Kernespecs[kernelName] = KernelspecModel{
 			Name:      kernelName,
@@ -77,3 +89,111 @@ func KernelspecAPIHandler(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
+
+// KernelspecInstallAPIHandler installs a kernelspec sent either as a JSON
+// {"name": ..., "spec": {...}} body, or as a multipart form with that JSON in
+// the "kernelspec" field and the logos as files named after them.
+func KernelspecInstallAPIHandler(w http.ResponseWriter, req *http.Request) {
+	var body KernelspecModel
+	resources := map[string][]byte{}
+
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := req.ParseMultipartForm(10 << 20); err != nil {
+			zhttp.SendErrorResponse(w, http.StatusBadRequest, "Unable to parse form")
+			return
+		}
+		if err := json.Unmarshal([]byte(req.FormValue("kernelspec")), &body); err != nil {
+			zhttp.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid kernelspec: %v", err))
+			return
+		}
+		for field, headers := range req.MultipartForm.File {
+			file, err := headers[0].Open()
+			if err != nil {
+				zhttp.SendErrorResponse(w, http.StatusBadRequest, "Unable to read file")
+				return
+			}
+			data, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				zhttp.SendErrorResponse(w, http.StatusBadRequest, "Unable to read file")
+				return
+			}
+			resources[field] = data
+		}
+	} else if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		zhttp.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid kernelspec: %v", err))
+		return
+	}
+
+	if err := InstallKernelSpec(body.Name, body.Spec, resources); err != nil {
+		log.Error().Msgf("Error installing kernelspec %s: %v", body.Name, err)
+		zhttp.SendErrorResponse(w, kernelspecErrorStatus(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(kernelspecModel(body.Name))
+}
+
+func KernelspecUpdateAPIHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	kernelName := vars["kernelName"]
+
+	var patch KernelspecPatch
+	if err := json.NewDecoder(req.Body).Decode(&patch); err != nil {
+		zhttp.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid kernelspec: %v", err))
+		return
+	}
+
+	if _, err := UpdateKernelSpec(kernelName, patch); err != nil {
+		log.Error().Msgf("Error updating kernelspec %s: %v", kernelName, err)
+		zhttp.SendErrorResponse(w, kernelspecErrorStatus(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(kernelspecModel(kernelName))
+}
+
+func KernelspecDeleteAPIHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	kernelName := vars["kernelName"]
+
+	if err := RemoveKernelSpec(kernelName); err != nil {
+		log.Error().Msgf("Error removing kernelspec %s: %v", kernelName, err)
+		zhttp.SendErrorResponse(w, kernelspecErrorStatus(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Kernelspec removed successfully",
+	})
+}
+
+func kernelspecModel(kernelName string) KernelspecModel {
+	spec := GetKernelSpec(kernelName)
+	return KernelspecModel{
+		Name:      kernelName,
+		Spec:      spec,
+		Resources: getResources(kernelName, spec.ResourceDir),
+	}
+}
+
+func kernelspecErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidKernelspec):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrKernelspecNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrKernelspecExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrKernelspecReadOnly):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,203 @@
+package kernelspec
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// specCache keeps the kernelspecs found by the last scan of the kernel
+// directories. It is invalidated when anything changes in these directories,
+// by the management API and by RefreshSpecs. Discovered Python environments
+// are only rescanned along with the kernelspecs.
+type specCache struct {
+	mu      sync.Mutex
+	specs   map[string]KspecData
+	scanned time.Time
+	watcher *fsnotify.Watcher
+	watched map[string]bool
+	// kernelDirs are the directories passed to the last watch
+	kernelDirs []string
+	// unwatched is set when some of the kernel directories could not be
+	// watched by the last watch
+	unwatched bool
+}
+
+// missRescanInterval is how often a missing kernelspec may trigger a scan
+// when some of the kernel directories cannot be watched.
+const missRescanInterval = 30 * time.Second
+
+var kernelspecCache = &specCache{}
+
+// get returns the cached kernelspecs, scanning the kernel directories when
+// the cache is empty.
+func (c *specCache) get() map[string]KspecData {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.specs == nil {
+		// watch first, so that changes made during the scan are not missed
+		c.watch(getKernelDirs())
+		c.specs = scanSpecs()
+		c.scanned = time.Now()
+	}
+	return c.specs
+}
+
+// lookup returns the kernelspec named kernelName. A kernelspec missing from
+// the cache is only searched for again when some of the kernel directories
+// are not watched, and at most every missRescanInterval, as scanning also
+// discovers the Python environments.
+func (c *specCache) lookup(kernelName string) (KspecData, bool) {
+	kspec, ok := c.get()[kernelName]
+	if ok {
+		return kspec, true
+	}
+	c.mu.Lock()
+	rescan := (c.watcher == nil || c.unwatched) && time.Since(c.scanned) > missRescanInterval
+	if rescan {
+		c.specs = nil
+	}
+	c.mu.Unlock()
+	if !rescan {
+		return KspecData{}, false
+	}
+	kspec, ok = c.get()[kernelName]
+	return kspec, ok
+}
+
+func (c *specCache) invalidate() {
+	c.mu.Lock()
+	c.specs = nil
+	c.mu.Unlock()
+}
+
+// watch adds fsnotify watches on the kernel directories and on each
+// kernelspec in them, or on the nearest existing parent of the directories
+// that do not exist yet. Creating such a directory invalidates the cache, and
+// the next get watches it in turn. c.mu must be held.
+func (c *specCache) watch(kernelDirs []string) {
+	if c.watcher == nil {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			log.Warn().Msgf("kernelspecs will not be refreshed automatically: %v", err)
+			return
+		}
+		c.watcher = watcher
+		c.watched = map[string]bool{}
+		go c.run(watcher)
+	}
+
+	c.kernelDirs = kernelDirs
+	c.unwatched = false
+	dirs := []string{}
+	for _, kernelDir := range kernelDirs {
+		if _, err := os.Stat(kernelDir); err != nil {
+			if parent, ok := existingParent(kernelDir); ok {
+				dirs = append(dirs, parent)
+			} else {
+				c.unwatched = true
+			}
+			continue
+		}
+		dirs = append(dirs, kernelDir)
+		for _, specDir := range subdirs(kernelDir) {
+			dirs = append(dirs, specDir)
+		}
+	}
+	for _, dir := range dirs {
+		if c.watched[dir] {
+			continue
+		}
+		if err := c.watcher.Add(dir); err != nil {
+			log.Debug().Msgf("cannot watch %s for kernelspecs: %v", dir, err)
+			c.unwatched = true
+			continue
+		}
+		c.watched[dir] = true
+	}
+}
+
+// existingParent returns the nearest parent of dir that exists.
+func existingParent(dir string) (string, bool) {
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		if info, err := os.Stat(parent); err == nil && info.IsDir() {
+			return parent, true
+		}
+		dir = parent
+	}
+}
+
+// affects reports whether a change to path can change the kernelspecs, that
+// is whether path is in a kernel directory or is one of their parents. The
+// parents watched for missing kernel directories see unrelated changes too.
+func (c *specCache) affects(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, kernelDir := range c.kernelDirs {
+		if isWithin(path, kernelDir) || isWithin(kernelDir, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWithin reports whether path is dir or is inside it.
+func isWithin(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+func (c *specCache) run(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+				c.mu.Lock()
+				delete(c.watched, event.Name)
+				c.mu.Unlock()
+			}
+			if c.affects(event.Name) {
+				log.Debug().Msgf("kernelspecs changed: %s", event)
+				c.invalidate()
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warn().Msgf("kernelspec watcher error: %v", err)
+		}
+	}
+}
+
+func scanSpecs() map[string]KspecData {
+	res := make(map[string]KspecData)
+	// installed kernelspecs take precedence over discovered environments
+	for kname, spec := range environmentSpecs() {
+		res[kname] = KspecData{Spec: spec}
+	}
+	for kname, resourceDir := range findKernelSpecs() {
+		res[kname] = KspecData{
+			Spec:        fromResourceDir(resourceDir),
+			ResourceDir: resourceDir,
+		}
+	}
+	return res
+}
+
+// RefreshSpecs drops the cached kernelspecs and scans the kernel directories
+// and Python environments again.
+func RefreshSpecs() map[string]KspecData {
+	kernelspecCache.invalidate()
+	return GetAllSpecs()
+}
@@ -0,0 +1,105 @@
+package kernelspec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zasper-io/zasper/internal/core"
+)
+
+func writeSpec(t *testing.T, dir, name, displayName string) {
+	t.Helper()
+	specDir := filepath.Join(dir, "kernels", name)
+	require.NoError(t, os.MkdirAll(specDir, 0755))
+	spec := `{"argv": ["kernel", "{connection_file}"], "display_name": "` + displayName + `", "language": "text"}`
+	require.NoError(t, os.WriteFile(filepath.Join(specDir, "kernel.json"), []byte(spec), 0644))
+}
+
+func TestSpecCacheFollowsKernelDirectories(t *testing.T) {
+	_, systemDir := setUpKernelspecDirs(t)
+	assert.Empty(t, GetAllSpecs())
+
+	// the kernels directory does not exist yet
+	writeSpec(t, systemDir, "first", "First")
+	assert.Eventually(t, func() bool {
+		return GetAllSpecs()["first"].Spec.DisplayName == "First"
+	}, 5*time.Second, 20*time.Millisecond)
+
+	writeSpec(t, systemDir, "first", "First, edited")
+	assert.Eventually(t, func() bool {
+		return GetAllSpecs()["first"].Spec.DisplayName == "First, edited"
+	}, 5*time.Second, 20*time.Millisecond)
+
+	require.NoError(t, os.RemoveAll(filepath.Join(systemDir, "kernels", "first")))
+	assert.Eventually(t, func() bool {
+		_, ok := GetAllSpecs()["first"]
+		return !ok
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestSpecCacheFollowsMissingParents(t *testing.T) {
+	_, systemDir := setUpKernelspecDirs(t)
+	jupyterDir := filepath.Join(systemDir, "share", "jupyter")
+	core.Zasper.JupyterPath = []string{jupyterDir}
+	assert.Empty(t, GetAllSpecs())
+
+	// neither the kernels directory nor its parents exist yet
+	writeSpec(t, jupyterDir, "deep", "Deep")
+	assert.Eventually(t, func() bool {
+		return GetKernelSpec("deep").DisplayName == "Deep"
+	}, 5*time.Second, 20*time.Millisecond)
+
+	writeSpec(t, jupyterDir, "deep", "Deep, edited")
+	assert.Eventually(t, func() bool {
+		return GetKernelSpec("deep").DisplayName == "Deep, edited"
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestMissingKernelDirIsRescanned(t *testing.T) {
+	setUpKernelspecDirs(t)
+	GetAllSpecs()
+
+	kernelspecCache.mu.Lock()
+	kernelspecCache.unwatched = true
+	kernelspecCache.scanned = time.Now().Add(-2 * missRescanInterval)
+	scanned := kernelspecCache.scanned
+	kernelspecCache.mu.Unlock()
+
+	assert.Empty(t, GetKernelSpec("does-not-exist").Argv)
+
+	kernelspecCache.mu.Lock()
+	defer kernelspecCache.mu.Unlock()
+	assert.True(t, kernelspecCache.scanned.After(scanned))
+}
+
+func TestGetKernelSpecIsACopy(t *testing.T) {
+	_, systemDir := setUpKernelspecDirs(t)
+	writeSpec(t, systemDir, "text", "Text")
+
+	spec := GetKernelSpec("text")
+	require.Equal(t, []string{"kernel", "{connection_file}"}, spec.Argv)
+	spec.Argv[1] = "/tmp/kernel-1.json"
+
+	assert.Equal(t, "{connection_file}", GetKernelSpec("text").Argv[1])
+}
+
+func TestMissingKernelSpecDoesNotRescan(t *testing.T) {
+	setUpKernelspecDirs(t)
+	GetAllSpecs()
+	kernelspecCache.mu.Lock()
+	scanned := kernelspecCache.scanned
+	kernelspecCache.mu.Unlock()
+
+	for i := 0; i < 3; i++ {
+		assert.Empty(t, GetKernelSpec("does-not-exist").Argv)
+	}
+
+	kernelspecCache.mu.Lock()
+	defer kernelspecCache.mu.Unlock()
+	assert.NotNil(t, kernelspecCache.specs)
+	assert.Equal(t, scanned, kernelspecCache.scanned)
+}
@@ -0,0 +1,239 @@
+package kernelspec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+
+	"github.com/zasper-io/zasper/internal/core"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrInvalidKernelspec  = errors.New("invalid kernelspec")
+	ErrKernelspecExists   = errors.New("kernelspec already exists")
+	ErrKernelspecNotFound = errors.New("no such kernelspec")
+	ErrKernelspecReadOnly = errors.New("kernelspec is not installed in the user data directory")
+)
+
+// resourceFiles are the files that may be uploaded along with a kernelspec.
+var resourceFiles = []string{"logo-32x32.png", "logo-64x64.png", "logo-svg.svg"}
+
+var validKernelName = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9._-]*$`)
+
+// KernelspecPatch holds the fields of a kernelspec that can be edited. Nil
+// fields are left unchanged.
+type KernelspecPatch struct {
+	Argv        []string          `json:"argv"`
+	Env         map[string]string `json:"env"`
+	DisplayName *string           `json:"display_name"`
+}
+
+// kernelJSON is the content of kernel.json.
+type kernelJSON struct {
+	Argv          []string          `json:"argv"`
+	DisplayName   string            `json:"display_name"`
+	Language      string            `json:"language"`
+	Env           map[string]string `json:"env,omitempty"`
+	Metadata      interface{}       `json:"metadata,omitempty"`
+	InterruptMode string            `json:"interrupt_mode,omitempty"`
+}
+
+func userKernelsDir() (string, error) {
+	if core.Zasper.JupyterDataDir == "" {
+		return "", errors.New("no Jupyter data directory")
+	}
+	return filepath.Join(core.Zasper.JupyterDataDir, "kernels"), nil
+}
+
+func validateKernelspec(name string, spec KernelSpecJsonData) error {
+	if !validKernelName.MatchString(name) {
+		return fmt.Errorf("%w: name %q may only contain letters, numbers, '.', '_' and '-', and not start with '.'", ErrInvalidKernelspec, name)
+	}
+	if len(spec.Argv) == 0 {
+		return fmt.Errorf("%w: argv is empty", ErrInvalidKernelspec)
+	}
+	if !slices.Contains(spec.Argv, "{connection_file}") {
+		return fmt.Errorf("%w: argv must contain {connection_file}", ErrInvalidKernelspec)
+	}
+	if spec.DisplayName == "" {
+		return fmt.Errorf("%w: display_name is empty", ErrInvalidKernelspec)
+	}
+	if spec.InterruptMode != "" && spec.InterruptMode != "signal" && spec.InterruptMode != "message" {
+		return fmt.Errorf("%w: interrupt_mode must be \"signal\" or \"message\"", ErrInvalidKernelspec)
+	}
+	return nil
+}
+
+// InstallKernelSpec writes a new kernelspec and its resource files to the
+// kernels directory of JupyterDataDir.
+func InstallKernelSpec(name string, spec KernelSpecJsonData, resources map[string][]byte) error {
+	if err := validateKernelspec(name, spec); err != nil {
+		return err
+	}
+	for file := range resources {
+		if !slices.Contains(resourceFiles, file) {
+			return fmt.Errorf("%w: unexpected resource %q, expected one of %v", ErrInvalidKernelspec, file, resourceFiles)
+		}
+	}
+
+	kernelsDir, err := userKernelsDir()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(kernelsDir, name)
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%w: %s", ErrKernelspecExists, name)
+	}
+	if err := os.MkdirAll(kernelsDir, 0755); err != nil {
+		return err
+	}
+
+	// write everything to a temporary directory first so that a half
+	// written kernelspec is never listed
+	tmpDir, err := os.MkdirTemp(kernelsDir, ".install-"+name+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := writeKernelJSON(tmpDir, kernelJSON{
+		Argv:          spec.Argv,
+		DisplayName:   spec.DisplayName,
+		Language:      spec.Language,
+		Env:           spec.Env,
+		Metadata:      spec.Metadata,
+		InterruptMode: spec.InterruptMode,
+	}); err != nil {
+		return err
+	}
+	for file, data := range resources {
+		if err := os.WriteFile(filepath.Join(tmpDir, file), data, 0644); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpDir, dir); err != nil {
+		if _, statErr := os.Stat(dir); statErr == nil {
+			return fmt.Errorf("%w: %s", ErrKernelspecExists, name)
+		}
+		return err
+	}
+	kernelspecCache.invalidate()
+	log.Info().Msgf("installed kernelspec %s in %s", name, dir)
+	return nil
+}
+
+// userKernelDir returns the directory of a kernelspec installed in
+// JupyterDataDir, the only ones that may be changed.
+func userKernelDir(name string) (string, error) {
+	if !validKernelName.MatchString(name) {
+		return "", fmt.Errorf("%w: %s", ErrKernelspecNotFound, name)
+	}
+	kernelsDir, err := userKernelsDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(kernelsDir, name)
+	if isKernelDir(dir) {
+		return dir, nil
+	}
+	if findSpecDirectory(name) != "" {
+		return "", fmt.Errorf("%w: %s", ErrKernelspecReadOnly, name)
+	}
+	if _, ok := GetAllSpecs()[name]; ok {
+		return "", fmt.Errorf("%w: %s is a discovered environment", ErrKernelspecReadOnly, name)
+	}
+	return "", fmt.Errorf("%w: %s", ErrKernelspecNotFound, name)
+}
+
+// RemoveKernelSpec deletes a kernelspec installed in JupyterDataDir.
+func RemoveKernelSpec(name string) error {
+	dir, err := userKernelDir(name)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	kernelspecCache.invalidate()
+	log.Info().Msgf("removed kernelspec %s from %s", name, dir)
+	return nil
+}
+
+// UpdateKernelSpec edits a kernelspec installed in JupyterDataDir, keeping
+// the fields of kernel.json it does not know about.
+func UpdateKernelSpec(name string, patch KernelspecPatch) (KernelSpecJsonData, error) {
+	dir, err := userKernelDir(name)
+	if err != nil {
+		return KernelSpecJsonData{}, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "kernel.json"))
+	if err != nil {
+		return KernelSpecJsonData{}, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return KernelSpecJsonData{}, fmt.Errorf("%w: %v", ErrInvalidKernelspec, err)
+	}
+	if patch.Argv != nil {
+		fields["argv"] = patch.Argv
+	}
+	if patch.Env != nil {
+		fields["env"] = patch.Env
+	}
+	if patch.DisplayName != nil {
+		fields["display_name"] = *patch.DisplayName
+	}
+
+	// validate the result as it will be read
+	data, err = json.Marshal(fields)
+	if err != nil {
+		return KernelSpecJsonData{}, err
+	}
+	var spec KernelSpecJsonData
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return KernelSpecJsonData{}, fmt.Errorf("%w: %v", ErrInvalidKernelspec, err)
+	}
+	if err := validateKernelspec(name, spec); err != nil {
+		return KernelSpecJsonData{}, err
+	}
+
+	if err := writeKernelJSON(dir, fields); err != nil {
+		return KernelSpecJsonData{}, err
+	}
+	kernelspecCache.invalidate()
+	log.Info().Msgf("updated kernelspec %s", name)
+	return fromResourceDir(dir), nil
+}
+
+// writeKernelJSON replaces kernel.json atomically.
+func writeKernelJSON(dir string, content interface{}) error {
+	data, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".kernel.json-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, "kernel.json"))
+}
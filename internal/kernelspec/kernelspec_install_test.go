@@ -0,0 +1,132 @@
+package kernelspec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zasper-io/zasper/internal/core"
+)
+
+func setUpKernelspecDirs(t *testing.T) (string, string) {
+	t.Helper()
+	dataDir := t.TempDir()
+	systemDir := t.TempDir()
+	core.Zasper.JupyterDataDir = dataDir
+	core.Zasper.JupyterPath = []string{systemDir}
+	core.Zasper.HomeDir = t.TempDir()
+	t.Setenv("HOME", t.TempDir())
+	kernelspecCache.invalidate()
+	// stop watching before the directories are removed, fsnotify races on
+	// the removal of a watched directory with the next test's watches
+	t.Cleanup(func() {
+		kernelspecCache.mu.Lock()
+		defer kernelspecCache.mu.Unlock()
+		if kernelspecCache.watcher != nil {
+			kernelspecCache.watcher.Close()
+			kernelspecCache.watcher = nil
+		}
+		kernelspecCache.specs = nil
+	})
+	return dataDir, systemDir
+}
+
+func testSpec() KernelSpecJsonData {
+	return KernelSpecJsonData{
+		Argv:        []string{"/opt/julia/bin/julia", "-i", "kernel.jl", "{connection_file}"},
+		DisplayName: "Julia 1.11",
+		Language:    "julia",
+		Env:         map[string]string{"JULIA_NUM_THREADS": "4"},
+	}
+}
+
+func TestInstallKernelSpec(t *testing.T) {
+	dataDir, _ := setUpKernelspecDirs(t)
+
+	logo := []byte("\x89PNG")
+	require.NoError(t, InstallKernelSpec("julia-1.11", testSpec(), map[string][]byte{"logo-64x64.png": logo}))
+
+	spec := GetKernelSpec("julia-1.11")
+	assert.Equal(t, testSpec().Argv, spec.Argv)
+	assert.Equal(t, "4", spec.Env["JULIA_NUM_THREADS"])
+	assert.Equal(t, filepath.Join(dataDir, "kernels", "julia-1.11"), spec.ResourceDir)
+	assert.Contains(t, GetAllSpecs(), "julia-1.11")
+	assert.FileExists(t, filepath.Join(spec.ResourceDir, "logo-64x64.png"))
+
+	assert.ErrorIs(t, InstallKernelSpec("julia-1.11", testSpec(), nil), ErrKernelspecExists)
+
+	entries, err := os.ReadDir(filepath.Join(dataDir, "kernels"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files were left behind")
+}
+
+func TestInstallInvalidKernelSpec(t *testing.T) {
+	setUpKernelspecDirs(t)
+
+	noConnectionFile := testSpec()
+	noConnectionFile.Argv = []string{"julia"}
+	noDisplayName := testSpec()
+	noDisplayName.DisplayName = ""
+
+	tests := []struct {
+		name      string
+		kernel    string
+		spec      KernelSpecJsonData
+		resources map[string][]byte
+	}{
+		{"traversal", "../julia", testSpec(), nil},
+		{"hidden", ".julia", testSpec(), nil},
+		{"no connection file", "julia", noConnectionFile, nil},
+		{"no display name", "julia", noDisplayName, nil},
+		{"unexpected resource", "julia", testSpec(), map[string][]byte{"../kernel.js": nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, InstallKernelSpec(tt.kernel, tt.spec, tt.resources), ErrInvalidKernelspec)
+		})
+	}
+}
+
+func TestUpdateKernelSpec(t *testing.T) {
+	dataDir, _ := setUpKernelspecDirs(t)
+	dir := filepath.Join(dataDir, "kernels", "ir")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	spec := `{"argv": ["R", "--slave", "-e", "IRkernel::main()", "--args", "{connection_file}"], "display_name": "R", "language": "R", "codemirror_mode": "r"}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "kernel.json"), []byte(spec), 0644))
+
+	displayName := "R 4.4"
+	updated, err := UpdateKernelSpec("ir", KernelspecPatch{
+		DisplayName: &displayName,
+		Env:         map[string]string{"R_LIBS_USER": "/srv/r-libs"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "R 4.4", updated.DisplayName)
+	assert.Equal(t, "/srv/r-libs", updated.Env["R_LIBS_USER"])
+	assert.Equal(t, "R", updated.Argv[0])
+
+	data, err := os.ReadFile(filepath.Join(dir, "kernel.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"codemirror_mode": "r"`)
+
+	_, err = UpdateKernelSpec("ir", KernelspecPatch{Argv: []string{}})
+	assert.ErrorIs(t, err, ErrInvalidKernelspec)
+}
+
+func TestRemoveKernelSpec(t *testing.T) {
+	_, systemDir := setUpKernelspecDirs(t)
+	require.NoError(t, InstallKernelSpec("julia", testSpec(), nil))
+
+	systemSpecDir := filepath.Join(systemDir, "kernels", "python3")
+	require.NoError(t, os.MkdirAll(systemSpecDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(systemSpecDir, "kernel.json"), []byte(`{"argv": ["python3"]}`), 0644))
+
+	require.NoError(t, RemoveKernelSpec("julia"))
+	assert.NotContains(t, GetAllSpecs(), "julia")
+
+	assert.ErrorIs(t, RemoveKernelSpec("julia"), ErrKernelspecNotFound)
+	assert.ErrorIs(t, RemoveKernelSpec("python3"), ErrKernelspecReadOnly)
+	assert.ErrorIs(t, RemoveKernelSpec(".."), ErrKernelspecNotFound)
+	assert.DirExists(t, systemSpecDir)
+}
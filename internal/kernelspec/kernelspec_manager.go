@@ -2,8 +2,10 @@ package kernelspec
 
 import (
 	"encoding/json"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/zasper-io/zasper/internal/core"
@@ -17,15 +19,15 @@ type KernelspecResponse struct {
 }
 
 type KernelSpecJsonData struct {
-	Argv          []string    `json:"argv"`
-	DisplayName   string      `json:"display_name"`
-	Language      string      `json:"language"`
-	Metadata      interface{} `json:"metadata"`
-	Name          string      `json:"name"`
-	Mimetype      string      `json:"mimetype"`
-	Env           string      `json:"env"`
-	ResourceDir   string      `json:"resource_dir"`
-	InterruptMode string      `json:"interrupt_mode"`
+	Argv          []string          `json:"argv"`
+	DisplayName   string            `json:"display_name"`
+	Language      string            `json:"language"`
+	Metadata      interface{}       `json:"metadata"`
+	Name          string            `json:"name"`
+	Mimetype      string            `json:"mimetype"`
+	Env           map[string]string `json:"env"`
+	ResourceDir   string            `json:"resource_dir"`
+	InterruptMode string            `json:"interrupt_mode"`
 }
 
 type KspecData struct {
@@ -53,23 +55,20 @@ func GetAllSpecs() map[string]KspecData {
 			  ...
 			}
 	*/
-	specs := findKernelSpecs()
-	res := make(map[string]KspecData)
-	for kname, resourceDir := range specs {
-		spec := fromResourceDir(resourceDir)
-
-		res[kname] = KspecData{
-			Spec:        spec,
-			ResourceDir: resourceDir,
-		}
-	}
-	return res
+	return maps.Clone(kernelspecCache.get())
 }
 
 func GetKernelSpec(kernelName string) KernelSpecJsonData {
-	resourceDir := findSpecDirectory(kernelName)
+	kspec, ok := kernelspecCache.lookup(kernelName)
+	if !ok {
+		return KernelSpecJsonData{}
+	}
 
-	return GetKernelSpecByName(kernelName, resourceDir)
+	// the caller may change the spec, e.g. to fill in the connection file
+	spec := kspec.Spec
+	spec.Argv = slices.Clone(spec.Argv)
+	spec.Env = maps.Clone(spec.Env)
+	return spec
 }
 
 func GetKernelSpecByName(kernelName, resourceDir string) KernelSpecJsonData {
@@ -116,6 +115,10 @@ func fromResourceDir(resourceDir string) KernelSpecJsonData {
 func getResources(kernelName, resourceDir string) map[string]string {
 
 	resources := make(map[string]string)
+	if resourceDir == "" {
+		// discovered environments have no resource files
+		return resources
+	}
 
 	// Check for static resource files
 	for _, resource := range []string{"kernel.js", "kernel.css"} {
@@ -172,7 +175,10 @@ func findKernelSpecs() map[string]string {
 	for _, kernelDir := range kernelDirs {
 		kernels := listKernelsIn(kernelDir)
 		for kname, spec := range kernels {
-			kernelsDict[kname] = spec
+			// the first directory wins, as in findSpecDirectory
+			if _, ok := kernelsDict[kname]; !ok {
+				kernelsDict[kname] = spec
+			}
 		}
 	}
 
@@ -181,6 +187,10 @@ func findKernelSpecs() map[string]string {
 
 func getKernelDirs() []string {
 	dirs := core.Zasper.JupyterPath
+	if dataDir := core.Zasper.JupyterDataDir; dataDir != "" && !slices.Contains(dirs, dataDir) {
+		// kernelspecs installed by the API take precedence
+		dirs = append([]string{dataDir}, dirs...)
+	}
 	kernel_dirs := []string{}
 	for _, v := range dirs {
 		kernel_dirs = append(kernel_dirs, filepath.Join(v, "kernels"))
@@ -202,7 +212,8 @@ func listKernelsIn(kernelDir string) map[string]string {
 	kernels := make(map[string]string)
 	for _, v := range files {
 		path := filepath.Join(kernelDir, v.Name())
-		if !isKernelDir(path) {
+		// hidden directories are kernelspecs being installed
+		if strings.HasPrefix(v.Name(), ".") || !isKernelDir(path) {
 			continue
 		}
 		kernels[v.Name()] = path
@@ -230,5 +241,8 @@ func isKernelDir(path string) bool {
 func getResourceFile(kernelName, resourcePath string) string {
 	// Construct the full path to the resource file
 	resourceDir := findSpecDirectory(kernelName)
+	if resourceDir == "" {
+		return ""
+	}
 	return filepath.Join(resourceDir, resourcePath)
 }
@@ -5,5 +5,17 @@ type KernelModel struct {
 	Name           string `json:"name"`
 	LastActivity   string `json:"last_activity"`
 	ExecutionState string `json:"execution_state"`
+	Reason         string `json:"reason,omitempty"`
 	Connections    int    `json:"connections"`
+
+	Resources *KernelResources `json:"resources,omitempty"`
+}
+
+// KernelResources is the resource usage of a kernel's process tree.
+type KernelResources struct {
+	Pid        int     `json:"pid"`
+	RSS        uint64  `json:"rss"`
+	CPUPercent float64 `json:"cpu_percent"`
+	OpenFiles  int     `json:"open_files"`
+	Children   int     `json:"children"`
 }
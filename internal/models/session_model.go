@@ -5,5 +5,6 @@ type SessionModel struct {
 	Path        string      `json:"path"`
 	Name        string      `json:"name"`
 	SessionType string      `json:"type"`
+	Cwd         string      `json:"cwd,omitempty"`
 	Kernel      KernelModel `json:"kernel"`
 }
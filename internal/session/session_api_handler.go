@@ -2,9 +2,12 @@ package session
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
+	"github.com/zasper-io/zasper/internal/gateway"
 	zhttp "github.com/zasper-io/zasper/internal/http"
+	"github.com/zasper-io/zasper/internal/kernel"
 	"github.com/zasper-io/zasper/internal/models"
 )
 
@@ -26,7 +29,18 @@ func SessionCreateApiHandler(w http.ResponseWriter, req *http.Request) {
 
 	sessions, err := CreateSession(body)
 	if err != nil {
-		zhttp.SendErrorResponse(w, http.StatusInternalServerError, "Failed to create session: "+err.Error())
+		status := http.StatusInternalServerError
+		if errors.Is(err, kernel.ErrNoSuchKernelspec) || errors.Is(err, kernel.ErrNoSuchKernel) {
+			status = http.StatusNotFound
+		}
+		if errors.Is(err, kernel.ErrPathOutsideProject) {
+			status = http.StatusBadRequest
+		}
+		var gwErr *gateway.Error
+		if errors.As(err, &gwErr) {
+			status = gateway.ErrorStatus(err)
+		}
+		zhttp.SendErrorResponse(w, status, "Failed to create session: "+err.Error())
 		return
 	}
 
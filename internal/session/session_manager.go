@@ -1,11 +1,15 @@
 package session
 
 import (
+	"encoding/json"
+	"os"
 	"path/filepath"
-	"time"
 
+	"github.com/zasper-io/zasper/internal/content"
 	"github.com/zasper-io/zasper/internal/core"
+	"github.com/zasper-io/zasper/internal/gateway"
 	"github.com/zasper-io/zasper/internal/kernel"
+	"github.com/zasper-io/zasper/internal/kernelspec"
 	"github.com/zasper-io/zasper/internal/models"
 
 	"github.com/google/uuid"
@@ -13,7 +17,15 @@ import (
 )
 
 func ListSessions() map[string]models.SessionModel {
-	return core.ZasperSession
+	sessions := core.ZasperSession.List()
+	for id, session := range sessions {
+		// the kernel state changes independently of the session
+		if kernelModel, err := getKernelModel(session.Kernel.Id); err == nil {
+			session.Kernel = kernelModel
+			sessions[id] = session
+		}
+	}
+	return sessions
 }
 
 func CreateSession(req models.SessionModel) (models.SessionModel, error) {
@@ -22,32 +34,45 @@ func CreateSession(req models.SessionModel) (models.SessionModel, error) {
 	*/
 	session_id := uuid.New().String()
 	var session models.SessionModel
-	session, ok := core.ZasperSession[req.Id]
+	session, ok := core.ZasperSession.Get(req.Id)
 	log.Debug().Msgf("creating session %s", req.Kernel.Name)
 	if ok {
 		//do something here
 		log.Debug().Msg("session exists")
 	} else {
-		kernelId, err := startKernelForSession(req.Path, req.Kernel.Name)
+		kernelName := resolveKernelName(req)
+		cwd, err := kernel.CwdForPath(req.Path)
+		if err != nil {
+			return session, err
+		}
+		env := getKernelEnv(cwd, req.Name)
+		if req.Cwd != "" {
+			// the session asks for another working directory
+			if cwd, err = kernel.CwdForPath(req.Cwd); err != nil {
+				return session, err
+			}
+		}
+		var kernelModel models.KernelModel
+		if req.Kernel.Id != "" {
+			// the session drives a running kernel, e.g. an attached one
+			kernelModel, err = getKernelModel(req.Kernel.Id)
+		} else {
+			kernelModel, err = startKernelForSession(cwd, kernelName, env)
+		}
 		if err != nil {
 			return session, err
 		}
-		log.Debug().Msgf("started kernel with id %s", kernelId)
+		log.Debug().Msgf("started kernel with id %s", kernelModel.Id)
 		// pendingSessions.update()
 		session = models.SessionModel{
 			Id:          session_id,
 			Name:        req.Name,
 			SessionType: req.SessionType,
 			Path:        req.Path,
-			Kernel: models.KernelModel{
-				Id:             kernelId,
-				Name:           req.Kernel.Name,
-				LastActivity:   time.Now().UTC().String(),
-				ExecutionState: "",
-				Connections:    0,
-			},
+			Cwd:         cwd,
+			Kernel:      kernelModel,
 		}
-		core.ZasperSession[session_id] = session
+		core.ZasperSession.Add(session)
 	}
 
 	return session, nil
@@ -58,46 +83,135 @@ func DeleteSession(req models.SessionModel) {
 		Deletes a Sesion
 	*/
 	log.Info().Msgf("deleting session %s", req.Id)
-	session, ok := core.ZasperSession[req.Id]
+	session, ok := core.ZasperSession.Remove(req.Id)
 	if !ok {
 		log.Info().Msg("session does not exist")
 		return
 	}
 	// stop kernel
 	stopKernelForSession(session.Kernel.Id)
-	// delete session
-
-	delete(core.ZasperSession, req.Id)
 }
 
-func startKernelForSession(path string, name string) (string, error) {
+func startKernelForSession(cwd string, kernelName string, env map[string]string) (models.KernelModel, error) {
 	/*
-		Starts a Jupyter Kernel for a new Sesion
+		Starts a Jupyter Kernel for a new Sesion, locally or on the gateway
 	*/
-	env := getKernelEnv(path, name)
 	log.Debug().Msg("starting kernel")
-	kernelId, err := kernel.StartKernelManager(path, name, env)
+	if gateway.Enabled() {
+		// the working directory is local to this machine
+		return gateway.Gateway.StartKernel(kernelName, env)
+	}
+	kernelId, err := kernel.StartKernelManager(cwd, kernelName, env)
 	if err != nil {
-		return "", err
+		return models.KernelModel{}, err
 	}
-	return kernelId, nil
+	return kernel.GetKernelModel(kernelId)
 }
 
 func stopKernelForSession(kernelId string) {
 	/*
 		Stops a Jupyter Kernel for a Sesion
 	*/
+	if gateway.Enabled() {
+		if err := gateway.Gateway.ShutdownKernel(kernelId); err != nil {
+			log.Error().Msgf("Error stopping kernel %s on the gateway: %v", kernelId, err)
+		}
+		return
+	}
+	if km, ok := kernel.GetKernelManager(kernelId); ok && !km.OwnsKernel {
+		// kernels started outside of Zasper stay attached
+		return
+	}
 	kernel.StopKernelManager(kernelId)
 }
 
-func getKernelEnv(path string, name string) map[string]string {
+func getKernelModel(kernelId string) (models.KernelModel, error) {
+	if gateway.Enabled() {
+		return gateway.Gateway.GetKernel(kernelId)
+	}
+	return kernel.GetKernelModel(kernelId)
+}
+
+func getKernelEnv(cwd string, name string) map[string]string {
 	/*
 		Get Kernel Environment variables
 	*/
-	// if name != nil
-	cwd := kernel.CwdForPath(path)
-	path = filepath.Join(cwd, name)
+	path := cwd
+	if name != "" {
+		path = filepath.Join(cwd, name)
+	}
 	env := make(map[string]string)
 	env["JPY_SESSION_NAME"] = path
 	return env
 }
+
+// resolveKernelName picks the kernelspec for a new session: the one asked for
+// by the client if it exists, else a kernelspec for the same language, or the
+// default kernelspec when the client does not name one.
+func resolveKernelName(req models.SessionModel) string {
+	if gateway.Enabled() {
+		return gatewayKernelName(req)
+	}
+	nb := notebookKernel(req.Path)
+	if req.Kernel.Name == "" {
+		return kernelspec.DefaultKernelspec(nb)
+	}
+	if name, ok := kernelspec.ResolveKernelName(req.Kernel.Name, nb); ok {
+		return name
+	}
+	return req.Kernel.Name
+}
+
+// gatewayKernelName is the kernelspec asked for by the client, or the default
+// kernelspec of the gateway.
+func gatewayKernelName(req models.SessionModel) string {
+	if req.Kernel.Name != "" {
+		return req.Kernel.Name
+	}
+	if name := notebookKernel(req.Path).Name; name != "" {
+		return name
+	}
+	specs, err := gateway.Gateway.Kernelspecs()
+	if err != nil {
+		log.Warn().Msgf("cannot get the default kernelspec of the gateway: %v", err)
+		return ""
+	}
+	return specs.Default
+}
+
+// notebookKernel reads the kernel recorded in the metadata of the notebook at
+// path, if there is one.
+func notebookKernel(path string) kernelspec.NotebookKernel {
+	if filepath.Ext(path) != ".ipynb" {
+		return kernelspec.NotebookKernel{}
+	}
+	osPath := content.GetSafePath(path)
+	if osPath == "" {
+		return kernelspec.NotebookKernel{}
+	}
+	data, err := os.ReadFile(osPath)
+	if err != nil {
+		return kernelspec.NotebookKernel{}
+	}
+
+	var nb struct {
+		Metadata struct {
+			Kernelspec struct {
+				Name     string `json:"name"`
+				Language string `json:"language"`
+			} `json:"kernelspec"`
+			LanguageInfo struct {
+				Name string `json:"name"`
+			} `json:"language_info"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &nb); err != nil {
+		log.Debug().Msgf("cannot read the metadata of %s: %v", path, err)
+		return kernelspec.NotebookKernel{}
+	}
+	language := nb.Metadata.Kernelspec.Language
+	if language == "" {
+		language = nb.Metadata.LanguageInfo.Name
+	}
+	return kernelspec.NotebookKernel{Name: nb.Metadata.Kernelspec.Name, Language: language}
+}
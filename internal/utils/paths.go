@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 
 	"github.com/rs/zerolog/log"
 )
@@ -33,16 +34,58 @@ func GetProjectName(absPath string) string {
 	return projectName
 }
 
+// GetJupyterConfigDir returns JUPYTER_CONFIG_DIR, or ~/.jupyter.
 func GetJupyterConfigDir() string {
-	return ""
+	if dir := os.Getenv("JUPYTER_CONFIG_DIR"); dir != "" {
+		return dir
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		log.Debug().Msgf("Failed to get home directory: %v", err)
+		return ""
+	}
+	return filepath.Join(homeDir, ".jupyter")
 }
 
+// GetJupyterDataDir returns the per-user Jupyter data directory, where user
+// kernelspecs are installed. JUPYTER_DATA_DIR overrides it.
 func GetJupyterDataDir() string {
-	return ""
+	if dir := os.Getenv("JUPYTER_DATA_DIR"); dir != "" {
+		return dir
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		log.Debug().Msgf("Failed to get home directory: %v", err)
+		return ""
+	}
+	switch runtime.GOOS {
+	case "windows":
+		if appData := os.Getenv("APPDATA"); appData != "" {
+			return filepath.Join(appData, "jupyter")
+		}
+		return filepath.Join(homeDir, "AppData", "Roaming", "jupyter")
+	case "darwin":
+		return filepath.Join(homeDir, "Library", "Jupyter")
+	default:
+		if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+			return filepath.Join(dataHome, "jupyter")
+		}
+		return filepath.Join(homeDir, ".local", "share", "jupyter")
+	}
 }
 
+// GetJupyterRuntimeDir returns the directory of the connection files and
+// sockets of kernels: JUPYTER_RUNTIME_DIR, or the runtime directory of the
+// Jupyter data directory.
 func GetJupyterRuntimeDir() string {
-	return ""
+	if dir := os.Getenv("JUPYTER_RUNTIME_DIR"); dir != "" {
+		return dir
+	}
+	dataDir := GetJupyterDataDir()
+	if dataDir == "" {
+		return ""
+	}
+	return filepath.Join(dataDir, "runtime")
 }
 
 // getPythonVersion tries to retrieve the installed Python version (e.g., "3.9")
@@ -65,8 +108,26 @@ func getPythonVersion() (string, error) {
 	return matches[1], nil
 }
 
-// GetJupyterPath returns a list of possible Jupyter paths, making the function user-agnostic
+// GetJupyterPath returns the Jupyter data directories in order of precedence:
+// the entries of JUPYTER_PATH, the user data directory, then the directories
+// reported by jupyter or the usual install locations. It runs jupyter, so it
+// should only be called once.
 func GetJupyterPath() []string {
+	candidates := filepath.SplitList(os.Getenv("JUPYTER_PATH"))
+	candidates = append(candidates, GetJupyterDataDir())
+	candidates = append(candidates, discoverJupyterPath()...)
+
+	paths := []string{}
+	for _, path := range candidates {
+		if path != "" && !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// discoverJupyterPath returns a list of possible Jupyter paths, making the function user-agnostic
+func discoverJupyterPath() []string {
 	// Try to get Jupyter data paths dynamically using 'jupyter --paths --json'
 	cmd := exec.Command("jupyter", "--paths", "--json")
 	var out bytes.Buffer
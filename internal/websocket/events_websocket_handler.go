@@ -0,0 +1,61 @@
+package websocket
+
+import (
+	"net/http"
+
+	"github.com/zasper-io/zasper/internal/core"
+	"github.com/zasper-io/zasper/internal/kernel"
+
+	"github.com/rs/zerolog/log"
+)
+
+// HandleEventsWebSocket pushes kernel status changes, and sessions being
+// created or deleted, to the client until it disconnects.
+func HandleEventsWebSocket(w http.ResponseWriter, req *http.Request) {
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to upgrade events connection")
+		return
+	}
+	defer conn.Close()
+
+	events, unsubscribe := kernel.ZasperActiveKernels.Subscribe()
+	defer unsubscribe()
+	sessionEvents, unsubscribeSessions := core.ZasperSession.Subscribe()
+	defer unsubscribeSessions()
+
+	// The client never sends anything meaningful, reading only detects the close.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			log.Debug().Msg("events websocket closed")
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				log.Debug().Msgf("Error writing event: %s", err)
+				return
+			}
+		case event, ok := <-sessionEvents:
+			if !ok {
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				log.Debug().Msgf("Error writing event: %s", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package websocket
+
+import (
+	"net/http"
+
+	"github.com/zasper-io/zasper/internal/kernel"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// HandleKernelLogsWebSocket sends the buffered output of a kernel process,
+// then streams new lines until the client disconnects.
+func HandleKernelLogsWebSocket(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	kernelId := vars["kernelId"]
+
+	km, ok := kernel.GetKernelManager(kernelId)
+	if !ok {
+		log.Error().Msg("kernel not found")
+		http.NotFound(w, req)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to upgrade kernel logs connection")
+		return
+	}
+	defer conn.Close()
+
+	backlog, lines, unsubscribe := km.Logs.Follow()
+	defer unsubscribe()
+
+	for _, line := range backlog {
+		if err := conn.WriteJSON(line); err != nil {
+			return
+		}
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			log.Debug().Msgf("kernel %s logs websocket closed", kernelId)
+			return
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if err := conn.WriteJSON(line); err != nil {
+				log.Debug().Msgf("Error writing log line: %s", err)
+				return
+			}
+		}
+	}
+}
@@ -3,6 +3,7 @@ package websocket
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
@@ -40,22 +41,27 @@ func KernelDeleteAPIHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	kernelID := vars["kernel_id"]
 
-	kwsConn := ZasperActiveKernelConnections[kernelID]
-
-	kwsConn.PollingCancel()
-
 	clientsMu.Lock()
+	kwsConn, ok := ZasperActiveKernelConnections[kernelID]
 	delete(ZasperActiveKernelConnections, kernelID)
 	clientsMu.Unlock()
 
+	if ok {
+		kwsConn.PollingCancel()
+	}
+
 	// Try to delete the kernel from "database"
 	err := kernel.KillKernelById(kernelID)
-	if err != nil {
-		// If the kernel is not found, respond with 404
+	if errors.Is(err, kernel.ErrNoSuchKernel) {
 		w.WriteHeader(http.StatusNotFound)
 		json.NewEncoder(w).Encode(APIResponse{Message: err.Error()})
 		return
 	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{Message: err.Error()})
+		return
+	}
 
 	// If deletion is successful, respond with 200 OK
 	w.WriteHeader(http.StatusOK)
@@ -70,7 +76,7 @@ func HandleWebSocket(w http.ResponseWriter, req *http.Request) {
 
 	log.Debug().Msgf("kernelName : %s, sessionId : %s", kernelId, sessionId)
 
-	session, ok := core.ZasperSession[sessionId]
+	session, ok := core.ZasperSession.Get(sessionId)
 
 	log.Debug().Msgf("session %v", session)
 	if !ok {
@@ -79,7 +85,7 @@ func HandleWebSocket(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	kernelManager, ok := kernel.ZasperActiveKernels[kernelId]
+	kernelManager, ok := kernel.GetKernelManager(kernelId)
 
 	if !ok {
 		log.Error().Msg("kernel not found")
@@ -111,7 +117,12 @@ func HandleWebSocket(w http.ResponseWriter, req *http.Request) {
 	kernelConnection.Prepare(sessionId)
 
 	log.Debug().Msg("connecting kernel")
-	kernelConnection.Connect()
+	if err := kernelConnection.Connect(); err != nil {
+		log.Error().Msgf("could not connect to kernel %s: %v", kernelId, err)
+		cancel()
+		conn.Close()
+		return
+	}
 
 	clientsMu.Lock()
 	ZasperActiveKernelConnections[kernelId] = &kernelConnection